@@ -1,10 +1,109 @@
 package golog
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"sync"
+	"sync/atomic"
 )
 
+type stdLogCapture struct {
+	out   io.Writer
+	flags int
+}
+
+// Restore the standard log package to the settings before CaptureStdLog() called.
+func (c *stdLogCapture) Close() error {
+	log.SetOutput(c.out)
+	log.SetFlags(c.flags)
+	return nil
+}
+
+// Implement io.Writer, p is written as one log of LevelInfo.
+func (l *Logger) Write(p []byte) (n int, err error) {
+	l.Output(LevelInfo, NormalDepth+1, string(p))
+	return len(p), nil
+}
+
+// Writer of CaptureStdLog(), the caller of the standard log package is reported
+type stdLogWriter struct {
+	l *Logger
+}
+
+func (w stdLogWriter) Write(p []byte) (n int, err error) {
+	// skip log.Printf() or log.(*Logger).Printf() and log.(*Logger).output()
+	w.l.Output(LevelInfo, NormalDepth+3, string(p))
+	return len(p), nil
+}
+
+type levelWriter struct {
+	l     *Logger
+	level LogLevel
+}
+
+func (w *levelWriter) Write(p []byte) (n int, err error) {
+	if !w.l.Enabled(w.level) {
+		return io.Discard.Write(p)
+	}
+	w.l.Output(w.level, NormalDepth+1, string(p))
+	return len(p), nil
+}
+
+// Returns an io.Writer writes p as one log of level, p is dropped without formatting if level disabled.
+func (l *Logger) WriterAt(level LogLevel) io.Writer {
+	return &levelWriter{l: l, level: level}
+}
+
+type lineWriter struct {
+	mu    sync.Mutex
+	l     *Logger
+	w     io.Writer
+	level LogLevel
+	buf   []byte // incomplete line
+}
+
+func (w *lineWriter) Write(p []byte) (n int, err error) {
+	if w.w != nil {
+		n, err = w.w.Write(p)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if w.l.enabled(w.level) {
+			w.l.output(w.level, NormalDepth+1, string(w.buf[:i]))
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Returns an io.Writer logs each line written to it as a log of level, lighter than AddRedirect() without a pipe.
+// If w is not nil, the data is also written to w. Incomplete line is kept until '\n' written.
+func (l *Logger) WrapWriter(w io.Writer, level LogLevel) io.Writer {
+	return &lineWriter{l: l, w: w, level: level}
+}
+
+// Redirect output of the standard log package to the Logger, for third-party libraries use log.Printf() directly.
+// Call Close() of the returned io.Closer to restore.
+func (l *Logger) CaptureStdLog() io.Closer {
+	c := &stdLogCapture{out: log.Writer(), flags: log.Flags()}
+	log.SetOutput(stdLogWriter{l: l})
+	log.SetFlags(0)
+	return c
+}
+
 // Provide compatible interface for the standard log package
 func (l *Logger) Print(v ...interface{}) { l.Output(LevelInfo, NormalDepth+1, fmt.Sprint(v...)) }
 
@@ -18,41 +117,44 @@ func (l *Logger) Printf(format string, v ...interface{}) {
 
 // Provide compatible interface for the standard log package
 func (l *Logger) Fatal(v ...interface{}) {
-	l.Output(LevelCritical, NormalDepth+1, fmt.Sprint(v...))
-	os.Exit(1)
+	s := fmt.Sprint(v...)
+	l.Output(LevelCritical, NormalDepth+1, s)
+	fatal(s)
 }
 
 // Provide compatible interface for the standard log package
 func (l *Logger) Fatalln(v ...interface{}) {
-	l.Output(LevelCritical, NormalDepth+1, fmt.Sprintln(v...))
-	os.Exit(1)
+	s := fmt.Sprintln(v...)
+	l.Output(LevelCritical, NormalDepth+1, s)
+	fatal(s)
 }
 
 // Provide compatible interface for the standard log package
 func (l *Logger) Fatalf(format string, v ...interface{}) {
-	l.Output(LevelCritical, NormalDepth+1, fmt.Sprintf(format, v...))
-	os.Exit(1)
+	s := fmt.Sprintf(format, v...)
+	l.Output(LevelCritical, NormalDepth+1, s)
+	fatal(s)
 }
 
 // Provide compatible interface for the standard log package
 func (l *Logger) Panic(v ...interface{}) {
 	s := fmt.Sprint(v...)
 	l.Output(LevelCritical, NormalDepth+1, s)
-	panic(s)
+	panicMsg(s)
 }
 
 // Provide compatible interface for the standard log package
 func (l *Logger) Panicln(v ...interface{}) {
 	s := fmt.Sprintln(v...)
 	l.Output(LevelCritical, NormalDepth+1, s)
-	panic(s)
+	panicMsg(s)
 }
 
 // Provide compatible interface for the standard log package
 func (l *Logger) Panicf(format string, v ...interface{}) {
 	s := fmt.Sprintf(format, v...)
 	l.Output(LevelCritical, NormalDepth+1, s)
-	panic(s)
+	panicMsg(s)
 }
 
 // Provide compatible interface for the standard log package
@@ -68,39 +170,74 @@ func Printf(format string, v ...interface{}) {
 
 // Provide compatible interface for the standard log package
 func Fatal(v ...interface{}) {
-	std.Output(LevelCritical, NormalDepth+1, fmt.Sprint(v...))
-	os.Exit(1)
+	s := fmt.Sprint(v...)
+	std.Output(LevelCritical, NormalDepth+1, s)
+	fatal(s)
 }
 
 // Provide compatible interface for the standard log package
 func Fatalln(v ...interface{}) {
-	std.Output(LevelCritical, NormalDepth+1, fmt.Sprintln(v...))
-	os.Exit(1)
+	s := fmt.Sprintln(v...)
+	std.Output(LevelCritical, NormalDepth+1, s)
+	fatal(s)
 }
 
 // Provide compatible interface for the standard log package
 func Fatalf(format string, v ...interface{}) {
-	std.Output(LevelCritical, NormalDepth+1, fmt.Sprintf(format, v...))
-	os.Exit(1)
+	s := fmt.Sprintf(format, v...)
+	std.Output(LevelCritical, NormalDepth+1, s)
+	fatal(s)
 }
 
 // Provide compatible interface for the standard log package
 func Panic(v ...interface{}) {
 	s := fmt.Sprint(v...)
 	std.Output(LevelCritical, NormalDepth+1, s)
-	panic(s)
+	panicMsg(s)
 }
 
 // Provide compatible interface for the standard log package
 func Panicln(v ...interface{}) {
 	s := fmt.Sprintln(v...)
 	std.Output(LevelCritical, NormalDepth+1, s)
-	panic(s)
+	panicMsg(s)
 }
 
 // Provide compatible interface for the standard log package
 func Panicf(format string, v ...interface{}) {
 	s := fmt.Sprintf(format, v...)
 	std.Output(LevelCritical, NormalDepth+1, s)
-	panic(s)
+	panicMsg(s)
+}
+
+// Replaces os.Exit(1) of Fatal methods and panic() of Panic methods, such as to run cleanup before exit
+type panicHandler struct {
+	fn func(level LogLevel, msg string)
+}
+
+var globalPanicHandler atomic.Value // panicHandler
+
+// Set fn called by Fatal and Panic methods instead of os.Exit(1) and panic(), nil to restore the default.
+// Fatal and Panic methods return after fn returned.
+func SetGlobalPanicHandler(fn func(level LogLevel, msg string)) {
+	globalPanicHandler.Store(panicHandler{fn: fn})
 }
+
+func fatal(msg string) {
+	if h, _ := globalPanicHandler.Load().(panicHandler); h.fn != nil {
+		h.fn(LevelCritical, msg)
+		return
+	}
+	os.Exit(1)
+}
+
+func panicMsg(msg string) {
+	if h, _ := globalPanicHandler.Load().(panicHandler); h.fn != nil {
+		h.fn(LevelCritical, msg)
+		return
+	}
+	panic(msg)
+}
+
+// Redirect output of the standard log package to the global logger
+func CaptureStdLog() io.Closer { return std.CaptureStdLog() }
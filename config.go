@@ -0,0 +1,98 @@
+package golog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const defaultFormat = "%(asctime) [%(levelno)][%(filename):%(function):%(lineno)] "
+
+// Config of a Logger, can be unmarshaled from config files
+type LoggerConfig struct {
+	Level      string `json:"level" yaml:"level"`   // "trace", "debug", "info", "warn", "error", "critical" or "emergency", default "info"
+	Format     string `json:"format" yaml:"format"` // header format, default is the same as the global logger
+	Output     string `json:"output" yaml:"output"` // "stderr", "stdout" or a file path, default "stderr"
+	Async      bool   `json:"async" yaml:"async"`
+	RotateMode string `json:"rotate_mode" yaml:"rotate_mode"` // "none", "hour", "day", "week", "size", "day_size" or "hour_size"
+	RotateSize int64  `json:"rotate_size" yaml:"rotate_size"` // used by size rotate modes
+	MaxFiles   int    `json:"max_files" yaml:"max_files"`     // not supported yet, must be 0
+	Compress   bool   `json:"compress" yaml:"compress"`       // not supported yet, must be false
+}
+
+var rotateModeNames = map[string]RotateMode{
+	"":          RotateNone,
+	"none":      RotateNone,
+	"hour":      RotateByHour,
+	"day":       RotateByDay,
+	"week":      RotateByWeek,
+	"size":      RotateBySize,
+	"day_size":  RotateByDayAndSize,
+	"hour_size": RotateByHourAndSize,
+}
+
+// Create a Logger by config
+func NewLoggerFromStruct(cfg LoggerConfig) (*Logger, error) {
+	if cfg.MaxFiles != 0 || cfg.Compress {
+		return nil, errors.New("max_files and compress are not supported")
+	}
+
+	level := LevelInfo
+	if cfg.Level != "" {
+		var err error
+		level, err = parseLevel(cfg.Level)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	format := cfg.Format
+	if format == "" {
+		format = defaultFormat
+	}
+
+	var out IOutput
+	var rw *RotateWriter
+	switch cfg.Output {
+	case "", "stderr":
+		out = NewConsoleWriter(os.Stderr)
+	case "stdout":
+		out = NewConsoleWriter(os.Stdout)
+	default:
+		mode, ok := rotateModeNames[cfg.RotateMode]
+		if !ok {
+			return nil, fmt.Errorf("unknown rotate mode [%s]", cfg.RotateMode)
+		}
+		w, err := NewRotateWriter(cfg.Output, mode)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.RotateSize > 0 {
+			w.SetRotateSize(cfg.RotateSize)
+		}
+		out = w
+		rw = w
+	}
+
+	l, err := NewLogger(out, level, format, cfg.Async)
+	if err != nil && rw != nil {
+		rw.Close()
+	}
+	return l, err
+}
+
+// Create a Logger by config of json read from r, such as a config file. r is closed if it is an io.Closer.
+// For other formats, unmarshal LoggerConfig and call NewLoggerFromStruct().
+func NewLoggerFromReader(r io.Reader) (*Logger, error) {
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	var cfg LoggerConfig
+	err := json.NewDecoder(r).Decode(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("parse logger config: %w", err)
+	}
+	return NewLoggerFromStruct(cfg)
+}
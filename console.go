@@ -1,50 +1,338 @@
 package golog
 
-import "io"
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
 
 // Write logs to console with colors
 type ConsoleWriter struct {
-	colored bool
-	brush   [int(LevelCritical) + 1][]byte
-	dst     io.Writer
+	mu             sync.Mutex
+	concurrent     bool // lock mu on writing, set by SetConcurrent()
+	colored        bool
+	colorSupported bool
+	brush          [numLevels][]byte
+	banner         []byte
+	prefix         []byte
+	prefixBrush    []byte
+	dst            io.Writer
+	column         bool
+	columns        columnFormatter
+	inProgress     bool              // last line written is a progress line
+	sessionColors  map[string][]byte // colors of header fields keyed by session names
 }
 
-var defaultBrush = [...][]byte{
-	int(LevelDebug):    []byte("\033[32m"),
-	int(LevelInfo):     []byte("\033[0m"),
-	int(LevelWarn):     []byte("\033[33;1m"),
-	int(LevelError):    []byte("\033[31;1m"),
-	int(LevelCritical): []byte("\033[35;1m"),
+// Pad the generated fields of a log header to fixed width, the fields are located by the header sessions of Logger
+type columnFormatter struct {
+	sessions []HeaderSession
+	widths   map[string]int
 }
 
+var defaultBrush = [numLevels][]byte{
+	int(LevelTrace - LevelTrace):     []byte("\033[36m"),
+	int(LevelDebug - LevelTrace):     []byte("\033[32m"),
+	int(LevelInfo - LevelTrace):      []byte("\033[0m"),
+	int(LevelWarn - LevelTrace):      []byte("\033[33;1m"),
+	int(LevelError - LevelTrace):     []byte("\033[31;1m"),
+	int(LevelCritical - LevelTrace):  []byte("\033[35;1m"),
+	int(LevelEmergency - LevelTrace): []byte("\033[5;31;1m"), // blinking red
+}
+
+// Brushes of levels from LevelTrace to LevelEmergency, see NewConsoleWriterWithTheme()
+type ConsoleTheme [numLevels]string
+
+var (
+	ThemeDefault = ConsoleTheme{
+		"\033[36m", "\033[32m", "\033[0m", "\033[33;1m", "\033[31;1m", "\033[35;1m", "\033[5;31;1m",
+	}
+	// 256 colors of the solarized palette
+	ThemeSolarized = ConsoleTheme{
+		"\033[38;5;245m", "\033[38;5;64m", "\033[38;5;33m", "\033[38;5;136m", "\033[38;5;160m", "\033[38;5;125m",
+		"\033[5;38;5;160m",
+	}
+	// no colors, levels are distinguished by dim, bold, underline and reverse
+	ThemeMonochrome = ConsoleTheme{
+		"\033[2m", "\033[2m", "\033[0m", "\033[1m", "\033[1;4m", "\033[1;7m", "\033[5;1;7m",
+	}
+	// bright colors for dark backgrounds
+	ThemeDark = ConsoleTheme{
+		"\033[90m", "\033[92m", "\033[97m", "\033[93;1m", "\033[91;1m", "\033[95;1m", "\033[5;91;1m",
+	}
+	// normal colors for light backgrounds
+	ThemeLight = ConsoleTheme{
+		"\033[36m", "\033[32m", "\033[30m", "\033[33m", "\033[31m", "\033[35m", "\033[5;31m",
+	}
+)
+
 var resetBrush = []byte("\033[0m")
+var defaultBannerBrush = []byte("\033[37;1m")
+var progressRewind = []byte("\033[1A\r\033[K") // cursor up, to line beginning and erase the line
 
-// Create a new ConsoleWriter
+// Create a new ConsoleWriter, colors are disabled if dst not supports ANSI escape codes,
+// or environment variable NO_COLOR is set (https://no-color.org), or TERM is "dumb".
 func NewConsoleWriter(dst io.Writer) *ConsoleWriter {
+	supported := checkColorSupported(dst) && os.Getenv("TERM") != "dumb"
 	w := &ConsoleWriter{
-		colored: true,
-		brush:   defaultBrush,
-		dst:     dst,
+		colored:        supported && os.Getenv("NO_COLOR") == "",
+		colorSupported: supported,
+		brush:          defaultBrush,
+		banner:         defaultBannerBrush,
+		dst:            dst,
+		concurrent:     true,
 	}
 	return w
 }
 
+// Create a new ConsoleWriter with colors of levels in colorMap, such as a house style of warns in bold blue.
+// Levels not in colorMap use the default colors.
+func NewColorWriter(dst io.Writer, colorMap map[LogLevel]string) *ConsoleWriter {
+	w := NewConsoleWriter(dst)
+	for level, brush := range colorMap {
+		if level >= LevelTrace && level <= LevelEmergency {
+			w.brush[levelIndex(level)] = []byte(brush)
+		}
+	}
+	return w
+}
+
+// Writes are locked by default to be called from multiple goroutines directly, such as GConsoleWriter.
+// Disable it for single goroutine use, such as the only output of a sync Logger which locks writes itself.
+func (w *ConsoleWriter) SetConcurrent(enabled bool) {
+	w.concurrent = enabled
+}
+
+// Create a new ConsoleWriter with brushes of theme, such as ThemeDark
+func NewConsoleWriterWithTheme(dst io.Writer, theme ConsoleTheme) *ConsoleWriter {
+	w := NewConsoleWriter(dst)
+	w.SetTheme(theme)
+	return w
+}
+
+// Replace brushes of all levels with theme
+func (w *ConsoleWriter) SetTheme(theme ConsoleTheme) {
+	for i, brush := range theme {
+		w.brush[i] = []byte(brush)
+	}
+}
+
 // Set display with color or not
 func (w *ConsoleWriter) SetColored(colored bool) {
 	w.colored = colored
 }
 
+// Returns whether dst supports ANSI escape codes, checked on NewConsoleWriter()
+func (w *ConsoleWriter) IsColorSupported() bool {
+	return w.colorSupported
+}
+
+// Returns whether logs are colored, disabled on NewConsoleWriter() by NO_COLOR or changed by SetColored()
+func (w *ConsoleWriter) IsColorEnabled() bool {
+	return w.colored
+}
+
 // Set colors for specified level of log
 func (w *ConsoleWriter) SetBrush(brush string, level LogLevel) {
-	w.brush[level] = []byte(brush)
+	w.brush[levelIndex(level)] = []byte(brush)
+}
+
+// Set a 256-color foreground for specified level of log, for terminals support 256 colors
+func (w *ConsoleWriter) SetBrush256(color byte, level LogLevel) {
+	w.brush[levelIndex(level)] = []byte("\033[38;5;" + strconv.Itoa(int(color)) + "m")
+}
+
+// Set a 24-bit truecolor foreground for specified level of log, for terminals support truecolor
+func (w *ConsoleWriter) SetBrushRGB(r, g, b byte, level LogLevel) {
+	w.brush[levelIndex(level)] = []byte("\033[38;2;" + strconv.Itoa(int(r)) + ";" + strconv.Itoa(int(g)) + ";" + strconv.Itoa(int(b)) + "m")
+}
+
+// Set color for a field of header, such as "asctime", the rest of the log is colored by level.
+// The field is located by the header sessions, and only if followed by a string const in the header format.
+func (w *ConsoleWriter) SetSessionColor(sessionName string, brush string) {
+	if w.sessionColors == nil {
+		w.sessionColors = map[string][]byte{}
+	}
+	w.sessionColors[sessionName] = []byte(brush)
+}
+
+// Set color for the time of header, same as SetSessionColor("asctime", brush)
+func (w *ConsoleWriter) SetTimestampColor(brush string) {
+	w.SetSessionColor("asctime", brush)
+}
+
+// Set a string written before every log, such as the service name when logs of services are interleaved
+func (w *ConsoleWriter) SetPrefix(prefix string) {
+	w.prefix = []byte(prefix)
+}
+
+// Set color for the prefix, not colored by default
+func (w *ConsoleWriter) SetPrefixBrush(brush string) {
+	w.prefixBrush = []byte(brush)
+}
+
+// Set color for checkpoint banners, bold white by default
+func (w *ConsoleWriter) SetBannerBrush(brush string) {
+	w.banner = []byte(brush)
+}
+
+// Enable to align header fields in columns, widths of fields are set by SetColumnWidths(). If the writer is shared by
+// loggers of different header formats, only logs of the last logger added are aligned.
+func (w *ConsoleWriter) SetColumnMode(enabled bool) {
+	w.column = enabled
+}
+
+// Set column widths of header fields, keys are the session names of the header format, such as "filename".
+func (w *ConsoleWriter) SetColumnWidths(widths map[string]int) {
+	w.columns.widths = widths
+}
+
+// Called by Logger on the ConsoleWriter added or the header changed. A ConsoleWriter shared by loggers, such as
+// GConsoleWriter, keeps the sessions of the last one, logs of other header formats are not aligned or colored by
+// sessions. Locked the same as writes, which read the sessions.
+func (w *ConsoleWriter) setHeaderSessions(sessions []HeaderSession) {
+	if w.concurrent {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+	}
+	w.columns.sessions = sessions
+}
+
+// Returns "ConsoleWriter:stdout" or "ConsoleWriter:stderr", "ConsoleWriter" for other dst
+func (w *ConsoleWriter) String() string {
+	switch w.dst {
+	case os.Stdout:
+		return "ConsoleWriter:stdout"
+	case os.Stderr:
+		return "ConsoleWriter:stderr"
+	}
+	return "ConsoleWriter"
 }
 
 func (w *ConsoleWriter) Write(msg []byte, level LogLevel) {
+	if w.concurrent {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+	}
+	w.inProgress = false
+	w.write(msg, w.brush[levelIndex(level)])
+}
+
+func (w *ConsoleWriter) writeBanner(msg []byte, level LogLevel) {
+	if w.concurrent {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+	}
+	w.inProgress = false
+	w.write(msg, w.banner)
+}
+
+// Rewrite the last progress line in place if colored and dst supports ANSI escape codes
+func (w *ConsoleWriter) writeProgress(msg []byte, level LogLevel) {
+	if w.concurrent {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+	}
+	if w.inProgress && w.colored && w.colorSupported {
+		w.dst.Write(progressRewind)
+	}
+	w.inProgress = true
+	w.write(msg, w.brush[levelIndex(level)])
+}
+
+func (w *ConsoleWriter) write(msg []byte, brush []byte) {
+	if w.column {
+		msg = w.columns.format(msg)
+	}
+	if len(w.prefix) > 0 {
+		if w.colored && len(w.prefixBrush) > 0 {
+			w.dst.Write(w.prefixBrush)
+			w.dst.Write(w.prefix)
+			w.dst.Write(resetBrush)
+		} else {
+			w.dst.Write(w.prefix)
+		}
+	}
 	if w.colored {
-		w.dst.Write(w.brush[level])
+		if len(w.sessionColors) > 0 {
+			msg = w.colorSessions(msg, brush)
+		}
+		w.dst.Write(brush)
 		w.dst.Write(msg)
 		w.dst.Write(resetBrush)
 	} else {
 		w.dst.Write(msg)
 	}
 }
+
+// Wrap the fields with colors of SetSessionColor(), and restore brush of the log after each field.
+// Message is returned unchanged if it not matches the header sessions.
+func (w *ConsoleWriter) colorSessions(msg []byte, brush []byte) []byte {
+	sessions := w.columns.sessions
+	buf := make([]byte, 0, len(msg)+64)
+	pos := 0
+	copied := 0
+	for i, s := range sessions {
+		if s.IsCopy {
+			if !bytes.HasPrefix(msg[pos:], []byte(s.StrCopy)) {
+				return msg
+			}
+			pos += len(s.StrCopy)
+			continue
+		}
+
+		if i+1 >= len(sessions) || !sessions[i+1].IsCopy {
+			break
+		}
+		end := bytes.Index(msg[pos:], []byte(sessions[i+1].StrCopy))
+		if end < 0 {
+			return msg
+		}
+		if c, ok := w.sessionColors[s.Name]; ok {
+			buf = append(buf, msg[copied:pos]...)
+			buf = append(buf, c...)
+			buf = append(buf, msg[pos:pos+end]...)
+			buf = append(buf, resetBrush...)
+			buf = append(buf, brush...)
+			copied = pos + end
+		}
+		pos += end
+	}
+	return append(buf, msg[copied:]...)
+}
+
+// Message is returned unchanged if it not matches the header sessions, such as json logs.
+func (f *columnFormatter) format(msg []byte) []byte {
+	if len(f.sessions) == 0 || len(f.widths) == 0 {
+		return msg
+	}
+
+	buf := make([]byte, 0, len(msg)+32)
+	pos := 0
+	for i, s := range f.sessions {
+		if s.IsCopy {
+			if !bytes.HasPrefix(msg[pos:], []byte(s.StrCopy)) {
+				return msg
+			}
+			buf = append(buf, s.StrCopy...)
+			pos += len(s.StrCopy)
+			continue
+		}
+
+		// ATTENTION a field can only be located if followed by a string const
+		if i+1 >= len(f.sessions) || !f.sessions[i+1].IsCopy {
+			break
+		}
+		end := bytes.Index(msg[pos:], []byte(f.sessions[i+1].StrCopy))
+		if end < 0 {
+			return msg
+		}
+		buf = append(buf, msg[pos:pos+end]...)
+		for n := end; n < f.widths[s.Name]; n++ {
+			buf = append(buf, ' ')
+		}
+		pos += end
+	}
+	return append(buf, msg[pos:]...)
+}
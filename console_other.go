@@ -0,0 +1,10 @@
+//go:build !windows
+
+package golog
+
+import "io"
+
+// ANSI escape codes are supported by terminals except old Windows consoles
+func checkColorSupported(dst io.Writer) bool {
+	return true
+}
@@ -0,0 +1,29 @@
+package golog
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProgressRewind(t *testing.T) {
+	cases := []struct {
+		colored, supported bool
+		expected           string
+	}{
+		{false, false, "1/2\n2/2\n"},
+		{false, true, "1/2\n2/2\n"},
+		{true, false, "\033[0m1/2\n\033[0m\033[0m2/2\n\033[0m"},
+		{true, true, "\033[0m1/2\n\033[0m\033[1A\r\033[K\033[0m2/2\n\033[0m"},
+	}
+	for _, c := range cases {
+		var b bytes.Buffer
+		w := NewConsoleWriter(&b)
+		w.colored = c.colored
+		w.colorSupported = c.supported
+		w.writeProgress([]byte("1/2\n"), LevelInfo)
+		w.writeProgress([]byte("2/2\n"), LevelInfo)
+		if b.String() != c.expected {
+			t.Errorf("colored %v, supported %v, unexpected output %q", c.colored, c.supported, b.String())
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package golog
+
+import "context"
+
+type ctxFieldsKey struct{}
+
+// Return a context carries fields for json logs, such as request id of a goroutine serves the request.
+// Fields attached before are kept, and overridden by the new fields with same keys.
+func AttachGoroutine(ctx context.Context, fields Json) context.Context {
+	merged := Json{}
+	if old, ok := ctx.Value(ctxFieldsKey{}).(Json); ok {
+		for k, v := range old {
+			merged[k] = v
+		}
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, ctxFieldsKey{}, merged)
+}
+
+// Return a child logger adds the fields attached to ctx by AttachGoroutine() to every json log.
+// The child logger shares outputs and header format with l, and level of l still takes effect.
+func (l *Logger) FromContext(ctx context.Context) *Logger {
+	fields, _ := ctx.Value(ctxFieldsKey{}).(Json)
+	return &Logger{
+		loggerCore: l.loggerCore,
+		parent:     l,
+		level:      LevelTrace,
+		ctxFields:  fields,
+		prefix:     l.prefix,
+		scopeDepth: l.scopeDepth,
+	}
+}
+
+// Return a child of the global logger, see Logger.FromContext()
+func FromContext(ctx context.Context) *Logger { return std.FromContext(ctx) }
+
+type dynContext struct {
+	key     string
+	valueFn func() interface{}
+}
+
+// Add a field to every json log of l, valueFn is called on each OutputJson() for the current value,
+// such as connection state. The field with same key is replaced. Fields of the log items are not overridden.
+func (l *Logger) AddContext(key string, valueFn func() interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	old := l.getContexts()
+	contexts := make([]dynContext, 0, len(old)+1)
+	for _, c := range old {
+		if c.key != key {
+			contexts = append(contexts, c)
+		}
+	}
+	l.contexts.Store(append(contexts, dynContext{key: key, valueFn: valueFn}))
+}
+
+// Remove the field added by AddContext()
+func (l *Logger) RemoveContext(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	old := l.getContexts()
+	contexts := make([]dynContext, 0, len(old))
+	for _, c := range old {
+		if c.key != key {
+			contexts = append(contexts, c)
+		}
+	}
+	l.contexts.Store(contexts)
+}
+
+func (l *Logger) getContexts() []dynContext {
+	contexts, _ := l.contexts.Load().([]dynContext)
+	return contexts
+}
+
+// Add a dynamic field to json logs of the global logger, see Logger.AddContext()
+func AddContext(key string, valueFn func() interface{}) { std.AddContext(key, valueFn) }
+
+// Remove the field added by AddContext()
+func RemoveContext(key string) { std.RemoveContext(key) }
@@ -0,0 +1,106 @@
+package golog
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// Returned by AddOutputFromDSN() for schemes not supported
+var ErrUnknownScheme = errors.New("unknown output scheme")
+
+// Write logs to a udp or tcp connection, reconnect on next write if failed
+type netOutput struct {
+	network string
+	addr    string
+	conn    net.Conn
+}
+
+func (n *netOutput) Write(msg []byte, level LogLevel) {
+	if n.conn == nil {
+		conn, err := net.Dial(n.network, n.addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "golog dial %s://%s error [%v]\n", n.network, n.addr, err)
+			return
+		}
+		n.conn = conn
+	}
+	_, err := n.conn.Write(msg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "golog write %s://%s error [%v]\n", n.network, n.addr, err)
+		n.conn.Close()
+		n.conn = nil
+	}
+}
+
+// Create an output by dsn, the scheme selects output type and query parameters are options:
+//   - file:///var/log/app.log?rotate=day&rotate_size=1000000, rotate is the same as LoggerConfig.RotateMode
+//   - udp://host:port, tcp://host:port
+//   - console://stderr, console://stdout
+//   - syslog:///?tag=app for local syslog, syslog://host:port?tag=app&network=udp for remote
+//   - null:// drops all logs
+func NewOutputFromDSN(dsn string) (IOutput, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parse dsn %s: %w", dsn, err)
+	}
+	query := u.Query()
+
+	switch u.Scheme {
+	case "file":
+		if query.Get("max_files") != "" || query.Get("compress") != "" {
+			return nil, errors.New("max_files and compress are not supported")
+		}
+		mode, ok := rotateModeNames[query.Get("rotate")]
+		if !ok {
+			return nil, fmt.Errorf("unknown rotate mode [%s]", query.Get("rotate"))
+		}
+		w, err := NewRotateWriter(u.Path, mode)
+		if err != nil {
+			return nil, err
+		}
+		if s := query.Get("rotate_size"); s != "" {
+			size, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rotate_size [%s]", s)
+			}
+			w.SetRotateSize(size)
+		}
+		return w, nil
+	case "udp", "tcp":
+		return &netOutput{network: u.Scheme, addr: u.Host}, nil
+	case "console":
+		switch u.Host {
+		case "", "stderr":
+			return NewConsoleWriter(os.Stderr), nil
+		case "stdout":
+			return NewConsoleWriter(os.Stdout), nil
+		}
+		return nil, fmt.Errorf("unknown console [%s]", u.Host)
+	case "syslog":
+		network := query.Get("network")
+		if network == "" && u.Host != "" {
+			network = "udp"
+		}
+		return newSyslogOutput(network, u.Host, query.Get("tag"))
+	case "null":
+		return NewFuncOutput(func(msg []byte, level LogLevel) {}), nil
+	}
+	return nil, fmt.Errorf("%w [%s]", ErrUnknownScheme, u.Scheme)
+}
+
+// Add an output created by NewOutputFromDSN()
+func (l *Logger) AddOutputFromDSN(dsn string) error {
+	out, err := NewOutputFromDSN(dsn)
+	if err != nil {
+		return err
+	}
+	l.AddOutput(out)
+	return nil
+}
+
+// Add an output to the global logger, see NewOutputFromDSN()
+func AddOutputFromDSN(dsn string) error { return std.AddOutputFromDSN(dsn) }
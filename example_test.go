@@ -7,7 +7,7 @@ import (
 	log "github.com/thinkphoebe/golog"
 )
 
-func ExampleHelloWorld() {
+func Example_helloWorld() {
 	log.Infof("Hello world! this is Golog")
 }
 
@@ -28,14 +28,20 @@ func ExampleConsoleWriter() {
 
 func ExampleRotateWriter() {
 	fmtStr := `%(asctime) [%(levelno)][%(filename):%(lineno)] `
-	w := log.NewRotateWriter("Rotate.log", log.RotateBySize)
+	w, err := log.NewRotateWriter("Rotate.log", log.RotateBySize)
+	if err != nil {
+		// error handling
+	}
 	w.SetRotateSize(1000)
 	log.Init(w, log.LevelDebug, fmtStr, false)
 }
 
 func ExampleAddOutput() {
 	log.Infof("write to stderr")
-	wFile := log.NewRotateWriter("Multi.log", log.RotateByHour)
+	wFile, err := log.NewRotateWriter("Multi.log", log.RotateByHour)
+	if err != nil {
+		// error handling
+	}
 	log.AddOutput(wFile)
 	log.Infof("both write to both stderr and log file")
 	log.Infof("remove file output")
@@ -64,7 +70,11 @@ func ExampleAddRedirect() {
 
 func ExampleNewLogger() {
 	fmtStr := `%(asctime) [%(levelno)][%(filename):%(lineno)] `
-	logger, err := log.NewLogger(log.NewRotateWriter("golog.log", log.RotateByHour), log.LevelDebug, fmtStr, false)
+	w, err := log.NewRotateWriter("golog.log", log.RotateByHour)
+	if err != nil {
+		// error handling
+	}
+	logger, err := log.NewLogger(w, log.LevelDebug, fmtStr, false)
 	if err != nil {
 		// error handling
 	}
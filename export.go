@@ -0,0 +1,74 @@
+package golog
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Encode logs as json objects, text logs are parsed by the header sessions
+type exportOutput struct {
+	enc      *json.Encoder
+	sessions []HeaderSession
+}
+
+func (e *exportOutput) setHeaderSessions(sessions []HeaderSession) {
+	e.sessions = sessions
+}
+
+func (e *exportOutput) Write(msg []byte, level LogLevel) {
+	line := bytes.TrimRight(msg, "\n")
+	obj := line
+	// the first string const of header is written before json logs
+	if len(e.sessions) > 0 && e.sessions[0].IsCopy {
+		obj = bytes.TrimPrefix(obj, []byte(e.sessions[0].StrCopy))
+	}
+	if len(obj) > 0 && obj[0] == '{' && json.Valid(obj) {
+		e.enc.Encode(json.RawMessage(obj))
+		return
+	}
+	e.enc.Encode(parseHeader(e.sessions, line))
+}
+
+// Fields of the header are keyed by session names, the rest of line is keyed by "msg".
+// Parsing stops at a field not followed by a string const, or line not matches the header.
+func parseHeader(sessions []HeaderSession, line []byte) Json {
+	items := Json{}
+	pos := 0
+	for i, s := range sessions {
+		if s.IsCopy {
+			if !bytes.HasPrefix(line[pos:], []byte(s.StrCopy)) {
+				break
+			}
+			pos += len(s.StrCopy)
+			continue
+		}
+		if i+1 >= len(sessions) || !sessions[i+1].IsCopy {
+			break
+		}
+		end := bytes.Index(line[pos:], []byte(sessions[i+1].StrCopy))
+		if end < 0 {
+			break
+		}
+		items[s.Name] = string(line[pos : pos+end])
+		pos += end
+	}
+	items["msg"] = string(line[pos:])
+	return items
+}
+
+// Encode every log to enc as a json object, until StopExport() called. Json logs are encoded as is, and fields
+// of text logs are keyed by names of header sessions, such as "asctime", with the message keyed by "msg".
+// No lock, callers should not export or stop from multi-goroutines.
+func (l *Logger) ExportTo(enc *json.Encoder) {
+	l.StopExport()
+	l.exportOut = &exportOutput{enc: enc}
+	l.AddOutput(l.exportOut)
+}
+
+// Stop exporting logs started by ExportTo()
+func (l *Logger) StopExport() {
+	if l.exportOut != nil {
+		l.RemoveOutput(l.exportOut)
+		l.exportOut = nil
+	}
+}
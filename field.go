@@ -0,0 +1,246 @@
+package golog
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+type FieldType int
+
+const (
+	InterfaceType FieldType = iota
+	IntType
+	StringType
+	BoolType
+	FloatType
+	ErrorType
+	DurationType
+)
+
+// A typed key-value of structured logs, marshaled without reflection except InterfaceType
+type Field struct {
+	Key       string
+	Type      FieldType
+	Integer   int64
+	String    string
+	Float     float64
+	Interface interface{}
+}
+
+func IntField(k string, v int64) Field       { return Field{Key: k, Type: IntType, Integer: v} }
+func StringField(k string, v string) Field   { return Field{Key: k, Type: StringType, String: v} }
+func Float64Field(k string, v float64) Field { return Field{Key: k, Type: FloatType, Float: v} }
+func AnyField(k string, v interface{}) Field { return Field{Key: k, Type: InterfaceType, Interface: v} }
+
+func BoolField(k string, v bool) Field {
+	f := Field{Key: k, Type: BoolType}
+	if v {
+		f.Integer = 1
+	}
+	return f
+}
+
+// Field with key "error", nil err is marshaled as null
+func ErrorField(err error) Field {
+	if err == nil {
+		return Field{Key: "error", Type: InterfaceType}
+	}
+	return Field{Key: "error", Type: ErrorType, String: err.Error()}
+}
+
+// Duration is marshaled as string such as "1.5s"
+func DurationField(k string, d time.Duration) Field {
+	return Field{Key: k, Type: DurationType, Integer: int64(d)}
+}
+
+// Output a json log of header fields, "msg", fields and the fields added to OutputJson() logs in order,
+// without allocating a map. Keys set by SetFieldOrder() are output first.
+func (l *Logger) OutputStructured(level LogLevel, calldepth int, msg string, fields ...Field) {
+	if !l.enabled(level) {
+		return
+	}
+
+	all := make([]Field, 0, len(fields)+len(l.ctxFields)+len(l.defaultFields)+2)
+	all = append(all, StringField("msg", msg))
+	all = append(all, fields...)
+	l.visitJsonFields(func(k string) bool {
+		return hasField(all, k)
+	}, func(k string, v interface{}) {
+		all = append(all, AnyField(k, v))
+	})
+	if l.prefix != "" && !hasField(all, "_prefix") {
+		all = append(all, StringField("_prefix", l.prefix))
+	}
+
+	buf, err := l.formatFields(level, calldepth+1, all)
+	if err == nil {
+		l.write(buf, level)
+	}
+}
+
+func hasField(fields []Field, key string) bool {
+	for _, f := range fields {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Header fields are placed before fields
+func (l *Logger) formatFields(level LogLevel, calldepth int, fields []Field) ([]byte, error) {
+	var buf []byte
+	var headers []Field
+
+	item := LogItem{
+		Level:     level,
+		Calldepth: calldepth,
+	}
+	for index, s := range l.getHeaderSessions() {
+		if s.IsCopy {
+			// ATTENTION if first header session is string const, it will be added to header of json string
+			if index == 0 {
+				buf = append(buf, s.StrCopy...)
+			}
+		} else {
+			var value []byte
+			s.GenHeader(&value, &item)
+			headers = append(headers, StringField(s.Name, string(value)))
+		}
+	}
+
+	return appendFields(buf, rankFields(append(headers, fields...), l.getFieldOrder()))
+}
+
+func appendFields(buf []byte, fields []Field) ([]byte, error) {
+	buf = append(buf, '{')
+	var err error
+	for i, f := range fields {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf, err = appendField(buf, f)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(buf, '}', '\n'), nil
+}
+
+// Fields sorted by key, the same as json.Marshal() of the map
+func sortedFields(items Json) []Field {
+	fields := make([]Field, 0, len(items))
+	for k, v := range items {
+		fields = append(fields, AnyField(k, v))
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+	return fields
+}
+
+// Fields of keys in order first, then the others sorted by key
+func orderedFields(items Json, order []string) []Field {
+	return rankFields(sortedFields(items), order)
+}
+
+// Fields of keys in order first, the others keep their relative order
+func rankFields(fields []Field, order []string) []Field {
+	if len(order) == 0 {
+		return fields
+	}
+	rank := make(map[string]int, len(order))
+	for i, k := range order {
+		if _, ok := rank[k]; !ok {
+			rank[k] = i
+		}
+	}
+	rankOf := func(k string) int {
+		if r, ok := rank[k]; ok {
+			return r
+		}
+		return len(order)
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return rankOf(fields[i].Key) < rankOf(fields[j].Key) })
+	return fields
+}
+
+func appendField(buf []byte, f Field) ([]byte, error) {
+	buf = appendJsonString(buf, f.Key)
+	buf = append(buf, ':')
+	switch f.Type {
+	case IntType:
+		buf = strconv.AppendInt(buf, f.Integer, 10)
+	case StringType, ErrorType:
+		buf = appendJsonString(buf, f.String)
+	case BoolType:
+		buf = strconv.AppendBool(buf, f.Integer != 0)
+	case FloatType:
+		if math.IsNaN(f.Float) || math.IsInf(f.Float, 0) {
+			// not supported by json
+			buf = appendJsonString(buf, strconv.FormatFloat(f.Float, 'g', -1, 64))
+		} else {
+			buf = strconv.AppendFloat(buf, f.Float, 'g', -1, 64)
+		}
+	case DurationType:
+		buf = appendJsonString(buf, time.Duration(f.Integer).String())
+	default:
+		b, err := json.Marshal(f.Interface)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+	return buf, nil
+}
+
+// Escape the same as encoding/json, including HTML characters
+func appendJsonString(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		if c == '\u2028' || c == '\u2029' {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hex[c&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
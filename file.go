@@ -1,8 +1,17 @@
 package golog
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -13,17 +22,21 @@ const (
 	RotateByHour
 	RotateByDay
 	RotateBySize
+	RotateByWeek
+	RotateByDayAndSize  // rotate by day or size, whichever comes first
+	RotateByHourAndSize // rotate by hour or size, whichever comes first
 )
 
 const (
 	format_time_day  = "2006-01-02"
 	format_time_hour = "2006-01-02-15"
 	format_time_size = "2006-01-02.150405.999999"
+	format_time_week = "%04d-W%02d" // ISO week, time.Format has no week verb
 )
 
 const defaultRotateSize = 100 * 1000 * 1000 //100M
 
-// Write logs to file, support rotate by day, hour, size
+// Write logs to file, support rotate by day, hour, week, size
 type RotateWriter struct {
 	file       string
 	rotateMode RotateMode
@@ -32,66 +45,548 @@ type RotateWriter struct {
 	suffix     string
 	rotateFlag int
 	fp         *os.File
+	link       string // symlink to the active log file, updated on rotate
+	syncWrite  bool
+	buf        *bufio.Writer // buffer of fp, not nil if enabled by SetBufferSize()
+
+	rotateGrace  time.Duration
+	writeTimeout time.Duration
+	preRotate    func(currentFile string) error
+	stalled      bool   // last write timed out, reopen the file on next write
+	staleSuffix  string // suffix of the existing file needs rotate, renamed on the first write
+	staleModTime time.Time
+	maxDiskUsage int64       // <= 0 -> not limited, set by SetMaxDiskUsage()
+	atomicRotate bool        // replace the active file by a staging file on rotate, set by SetAtomicRotate()
+	stagingPath  string      // file + ".new" if empty
+	dirPerm      os.FileMode // 0755 if 0, set by SetDirPermissions()
+
+	totalWritten  atomic.Int64
+	rotationCount atomic.Int64
+	dropped       atomic.Int64
 }
 
-// Create a new RotateWriter
-func NewRotateWriter(file string, mode RotateMode) *RotateWriter {
-	w := &RotateWriter{file: file, rotateMode: mode, rotateSize: defaultRotateSize, rotateFlag: -1}
-	err := w.rotate()
+// Counters of RotateWriter, returned by Stat()
+type RotateStat struct {
+	File              string
+	TotalWrittenBytes int64
+	RotationCount     int64
+	DroppedCount      int64
+}
+
+// Create a new RotateWriter, the log file is checked writable and opened immediately.
+// The error is returned if the log file not writable, such as owned by another user.
+func NewRotateWriter(file string, mode RotateMode) (*RotateWriter, error) {
+	return newRotateWriter(file, "", mode)
+}
+
+// Create a new RotateWriter with a symlink at link points to the active log file, such as for log shippers.
+// The symlink is updated after every rotate, and skipped on platforms not support symlinks.
+func NewRotateWriterWithSymlink(file, link string, mode RotateMode) (*RotateWriter, error) {
+	return newRotateWriter(file, link, mode)
+}
+
+// No symlink if link is empty
+func newRotateWriter(file, link string, mode RotateMode) (*RotateWriter, error) {
+	err := checkWritable(file)
 	if err != nil {
-		return nil
+		return nil, err
+	}
+	w := &RotateWriter{file: file, rotateMode: mode, rotateSize: defaultRotateSize, rotateFlag: -1, link: link}
+	err = w.rotate()
+	if err != nil {
+		return nil, err
+	}
+	err = w.updateSymlink()
+	if err != nil {
+		w.fp.Close()
+		return nil, err
 	}
+	return w, nil
+}
+
+// Create a new RotateWriter, returns nil on failure.
+//
+// Deprecated: use NewRotateWriter and check the returned error.
+func NewRotateWriterMustSucceed(file string, mode RotateMode) *RotateWriter {
+	w, _ := NewRotateWriter(file, mode)
 	return w
 }
 
+// Returns "RotateWriter:" with the log file path
+func (w *RotateWriter) String() string {
+	return "RotateWriter:" + w.file
+}
+
 // No lock, callers lock if necessary
 func (w *RotateWriter) Write(msg []byte, level LogLevel) {
+	if w.stalled {
+		err := w.reopen()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "RotateWriter reopen error [%v]\n", err)
+			w.dropped.Add(1)
+			return
+		}
+		w.stalled = false
+	}
 	err := w.rotate()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "RotateWriter rotate error [%v]\n", err)
 		return
 	}
-	w.fp.Write(msg)
+	if w.writeTimeout > 0 {
+		if !w.writeWithTimeout(msg) {
+			w.dropped.Add(1)
+			w.stalled = true
+			return
+		}
+	} else {
+		w.dst().Write(msg)
+	}
 	w.writedSize += int64(len(msg))
+	w.totalWritten.Add(int64(len(msg)))
+}
+
+// Returns false if the write not finished in writeTimeout, it continues in background
+func (w *RotateWriter) writeWithTimeout(msg []byte) bool {
+	dst := w.dst()
+	buf := make([]byte, len(msg))
+	copy(buf, msg)
+	done := make(chan struct{})
+	go func() {
+		dst.Write(buf)
+		close(done)
+	}()
+
+	timer := time.NewTimer(w.writeTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Give up a write not finished in d, such as on a stalled NFS, the log is dropped and the file is reopened on
+// next write. 0 by default to wait forever. Returns error if buffered by SetBufferSize(), the buffer can not be
+// shared with the background write. No lock, callers lock if necessary.
+func (w *RotateWriter) SetWriteTimeout(d time.Duration) error {
+	if d > 0 && w.buf != nil {
+		return errBufferWithTimeout
+	}
+	w.writeTimeout = d
+	return nil
+}
+
+var errBufferWithTimeout = errors.New("buffer size and write timeout can not be set both")
+
+// Logs dropped by write timeout, safe to call without lock
+func (w *RotateWriter) DroppedCount() int64 {
+	return w.dropped.Load()
+}
+
+// Bytes written since the writer created, safe to call without lock
+func (w *RotateWriter) TotalWrittenBytes() int64 {
+	return w.totalWritten.Load()
+}
+
+// Times of doRotate called since the writer created, including the first open. Safe to call without lock.
+func (w *RotateWriter) RotationCount() int64 {
+	return w.rotationCount.Load()
+}
+
+func (w *RotateWriter) Stat() RotateStat {
+	return RotateStat{
+		File:              w.file,
+		TotalWrittenBytes: w.TotalWrittenBytes(),
+		RotationCount:     w.RotationCount(),
+		DroppedCount:      w.DroppedCount(),
+	}
+}
+
+// Rotate immediately, the rotated file is named with current time like RotateBySize.
+// No lock, callers lock if necessary.
+func (w *RotateWriter) Rotate() error {
+	next := w.suffix
+	w.suffix = time.Now().Format(format_time_size)
+	w.writedSize = 0
+	w.staleSuffix = ""
+	err := w.doRotate(next)
+	if err != nil {
+		w.suffix = next
+	}
+	return err
+}
+
+// Read the last n complete lines of the active log file, without the trailing '\n'.
+// The file is opened separately for read, so it can be called while writing. Empty for n <= 0.
+func (w *RotateWriter) ReadLastN(n int) ([][]byte, error) {
+	const chunkSize = 4096
+	if n <= 0 {
+		return [][]byte{}, nil
+	}
+
+	f, err := os.Open(w.file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	pos := info.Size()
+	// one more '\n' is needed to find the beginning of the first line
+	for pos > 0 && bytes.Count(buf, []byte{'\n'}) <= n {
+		size := int64(chunkSize)
+		if size > pos {
+			size = pos
+		}
+		pos -= size
+		chunk := make([]byte, size)
+		_, err = f.ReadAt(chunk, pos)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(chunk, buf...)
+	}
+
+	// drop the incomplete last line
+	end := bytes.LastIndexByte(buf, '\n')
+	if end < 0 {
+		return [][]byte{}, nil
+	}
+	lines := bytes.Split(buf[:end], []byte{'\n'})
+	if pos > 0 {
+		// the first line may be incomplete
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// Open log files with O_SYNC, every write is committed to disk before returned. It slows down writing much,
+// and is less meaningful for async loggers which lose the logs queued on crash.
+// The active log file is reopened. No lock, callers lock if necessary.
+func (w *RotateWriter) SetSyncWrite(enabled bool) error {
+	w.syncWrite = enabled
+	if w.fp == nil {
+		return nil
+	}
+	return w.reopen()
+}
+
+// Reopen the active log file. The old file is flushed and closed before returned, or closed in background if
+// stalled, the close may be blocked by the stalled write.
+func (w *RotateWriter) reopen() error {
+	f, err := os.OpenFile(w.file, w.openFlag(), 0666)
+	if err != nil {
+		return fmt.Errorf("open log file %s: %w", w.file, err)
+	}
+	if w.stalled {
+		go w.fp.Close()
+	} else {
+		w.Flush()
+		w.fp.Close()
+	}
+	w.fp = f
+	if w.buf != nil {
+		w.buf = bufio.NewWriterSize(f, w.buf.Size())
+	}
+	return nil
+}
+
+// Returns w.buf if buffered, or w.fp
+func (w *RotateWriter) dst() io.Writer {
+	if w.buf != nil {
+		return w.buf
+	}
+	return w.fp
+}
+
+// Buffer writes in memory up to size bytes to reduce syscalls, size <= 0 to write directly, by default.
+// Buffered logs are lost on crash if not flushed, Logger flushes it after Critical logs, on Close() and by
+// FlushTimeout(). Returns error if SetWriteTimeout() set. No lock, callers lock if necessary.
+func (w *RotateWriter) SetBufferSize(size int) error {
+	if size > 0 && w.writeTimeout > 0 {
+		return errBufferWithTimeout
+	}
+	err := w.Flush()
+	if size <= 0 {
+		w.buf = nil
+	} else {
+		w.buf = bufio.NewWriterSize(w.fp, size)
+	}
+	return err
+}
+
+// Write the buffered logs to the file. No lock, callers lock if necessary.
+func (w *RotateWriter) Flush() error {
+	if w.buf == nil {
+		return nil
+	}
+	return w.buf.Flush()
+}
+
+// Flush and close the active log file, it should be removed from the Logger before closed.
+func (w *RotateWriter) Close() error {
+	err := w.Flush()
+	if cerr := w.fp.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *RotateWriter) openFlag() int {
+	flag := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+	if w.syncWrite {
+		flag |= os.O_SYNC
+	}
+	return flag
+}
+
+// Not rotate the existing log file on startup if it was modified in d, such as the process restarted in a
+// crash loop around midnight. 0 by default to always rotate. No lock, callers lock if necessary.
+func (w *RotateWriter) SetRotateGrace(d time.Duration) {
+	w.rotateGrace = d
+}
+
+// Set fn called before the active log file renamed on rotate, such as to flush an external cache of the file.
+// The rotation is aborted if fn returns error. No lock, callers lock if necessary.
+func (w *RotateWriter) SetPreRotateHook(fn func(currentFile string) error) {
+	w.preRotate = fn
+}
+
+// Truncate the existing log file on startup rather than append to it, such as a fresh log file for each run of
+// batch jobs. The file is opened by the constructor, so it is truncated if nothing written yet, and an existing
+// file to be rotated by time is renamed on the first write instead. Returns error if it can no longer take effect,
+// the file has been written or rotated. No lock, callers lock if necessary.
+func (w *RotateWriter) SetTruncateOnOpen(enabled bool) error {
+	if !enabled || w.staleSuffix != "" {
+		return nil
+	}
+	if w.fp == nil || w.totalWritten.Load() != 0 || w.rotationCount.Load() != 1 {
+		return fmt.Errorf("truncate log file %s: already written or rotated", w.file)
+	}
+	err := w.fp.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("truncate log file %s: %w", w.file, err)
+	}
+	w.writedSize = 0
+	return nil
+}
+
+// Total bytes of the active log file and the rotated files named with its suffixes.
+// Only metadata of the files is read, safe to call without lock.
+func (w *RotateWriter) DiskUsage() (int64, error) {
+	files, err := w.rotatedFiles()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, f := range append(files, w.file) {
+		info, err := os.Stat(f)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// Delete the oldest rotated files after rotation while DiskUsage() exceeds bytes, <= 0 to disable, by default.
+// The active log file is never deleted. No lock, callers lock if necessary.
+func (w *RotateWriter) SetMaxDiskUsage(bytes int64) {
+	w.maxDiskUsage = bytes
+}
+
+// Compress the rotated files to destDir as <basename>.gz and remove them, destDir is created if not exists.
+// Files failed are kept and listed in the returned error, the others are still archived.
+// Only files are touched, safe to call without lock.
+func (w *RotateWriter) Archive(destDir string) error {
+	files, err := w.rotatedFiles()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(destDir, 0755)
+	if err != nil {
+		return fmt.Errorf("create archive directory %s: %w", destDir, err)
+	}
+	var errs []error
+	for _, f := range files {
+		err = archiveFile(f, filepath.Join(destDir, filepath.Base(f)+".gz"))
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func archiveFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("archive log file %s: %w", src, err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("archive log file %s: %w", src, err)
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(src)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("archive log file %s to %s: %w", src, dst, err)
+	}
+	err = os.Remove(src)
+	if err != nil {
+		return fmt.Errorf("remove archived log file %s: %w", src, err)
+	}
+	return nil
+}
+
+func (w *RotateWriter) rotatedFiles() ([]string, error) {
+	return filepath.Glob(w.file + ".*")
+}
+
+func (w *RotateWriter) limitDiskUsage() error {
+	usage, err := w.DiskUsage()
+	if err != nil || usage <= w.maxDiskUsage {
+		return err
+	}
+	files, err := w.rotatedFiles()
+	if err != nil {
+		return err
+	}
+	type rotatedFile struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var rotated []rotatedFile
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err == nil && !info.IsDir() {
+			rotated = append(rotated, rotatedFile{name: f, size: info.Size(), modTime: info.ModTime()})
+		}
+	}
+	sort.Slice(rotated, func(i, j int) bool { return rotated[i].modTime.Before(rotated[j].modTime) })
+	for _, f := range rotated {
+		if usage <= w.maxDiskUsage {
+			break
+		}
+		err = os.Remove(f.name)
+		if err != nil {
+			return fmt.Errorf("remove rotated log file %s: %w", f.name, err)
+		}
+		usage -= f.size
+	}
+	return nil
 }
 
-// Used by RotateBySize
+// Rotate by opening a staging file for new logs and renaming it to the active file as the final step, rather than
+// renaming the active file, so readers always see the active file. The active file is hard linked to the rotated
+// name, so the staging file should be on the same filesystem. No lock, callers lock if necessary.
+func (w *RotateWriter) SetAtomicRotate(enabled bool) {
+	w.atomicRotate = enabled
+}
+
+// Set permissions of the log directory created on rotate if not exists, 0755 by default.
+// The directory should exist on the writer created. No lock, callers lock if necessary.
+func (w *RotateWriter) SetDirPermissions(perm os.FileMode) {
+	w.dirPerm = perm
+}
+
+// Set path of the staging file of SetAtomicRotate(), the active file path with ".new" by default.
+func (w *RotateWriter) SetStagingPath(path string) {
+	w.stagingPath = path
+}
+
+// Used by RotateBySize, RotateByDayAndSize and RotateByHourAndSize
 func (w *RotateWriter) SetRotateSize(size int64) {
 	w.rotateSize = size
 }
 
 func (w *RotateWriter) rotate() error {
+	// ATTENTION the existing file is renamed on the first write rather than open, for SetRotateGrace() called after
+	// the writer created. The file is opened for append, nothing written before renamed.
+	if w.staleSuffix != "" && w.fp != nil {
+		stale := w.staleSuffix
+		w.staleSuffix = ""
+		if time.Since(w.staleModTime) >= w.rotateGrace {
+			next := w.suffix
+			w.suffix = stale
+			err := w.doRotate(next)
+			if err != nil {
+				w.suffix = next
+				return err
+			}
+		}
+	}
+
 	suffix := ""
 	rotate := false
 	t := time.Now()
+	byDay := w.rotateMode == RotateByDay || w.rotateMode == RotateByDayAndSize
+	byHour := w.rotateMode == RotateByHour || w.rotateMode == RotateByHourAndSize
+	bySize := w.rotateMode == RotateBySize || w.rotateMode == RotateByDayAndSize || w.rotateMode == RotateByHourAndSize
 
 	// on first write
 	if w.fp == nil {
 		rotate = true
 		info, err := os.Stat(w.file)
 		if err == nil {
-			if w.rotateMode == RotateByDay && info.ModTime().Day() != t.Day() {
-				w.suffix = info.ModTime().Format(format_time_day)
-			} else if w.rotateMode == RotateByHour && info.ModTime().Hour() != t.Hour() {
-				w.suffix = info.ModTime().Format(format_time_hour)
-			} else if w.rotateMode == RotateBySize {
+			w.staleModTime = info.ModTime()
+			if byDay && info.ModTime().Day() != t.Day() {
+				w.staleSuffix = info.ModTime().Format(format_time_day)
+			} else if byHour && info.ModTime().Hour() != t.Hour() {
+				w.staleSuffix = info.ModTime().Format(format_time_hour)
+			} else if w.rotateMode == RotateByWeek && weekFlag(info.ModTime()) != weekFlag(t) {
+				w.staleSuffix = weekSuffix(info.ModTime())
+			} else if bySize {
 				w.writedSize = info.Size()
 			}
 		}
 	}
 
-	if w.rotateMode == RotateByDay && w.rotateFlag != t.Day() {
+	if byDay && w.rotateFlag != t.Day() {
 		rotate = true
 		w.rotateFlag = t.Day()
 		suffix = t.Format(format_time_day)
-	} else if w.rotateMode == RotateByHour && w.rotateFlag != t.Hour() {
+		if w.fp != nil {
+			w.writedSize = 0
+		}
+	} else if byHour && w.rotateFlag != t.Hour() {
 		rotate = true
 		w.rotateFlag = t.Hour()
 		suffix = t.Format(format_time_hour)
-	} else if w.rotateMode == RotateBySize && w.writedSize > w.rotateSize {
+		if w.fp != nil {
+			w.writedSize = 0
+		}
+	} else if w.rotateMode == RotateByWeek && w.rotateFlag != weekFlag(t) {
+		rotate = true
+		w.rotateFlag = weekFlag(t)
+		suffix = weekSuffix(t)
+	} else if bySize && w.writedSize > w.rotateSize {
 		rotate = true
 		w.writedSize = 0
-		// ATTENTION use current time as rotated file name
+		// ATTENTION use current time as rotated file name, it begins with the day and hour of combined modes.
+		// The suffix of current day or hour is kept for the next time based rotate.
+		suffix = w.suffix
 		w.suffix = t.Format(format_time_size)
 	}
 
@@ -102,27 +597,170 @@ func (w *RotateWriter) rotate() error {
 }
 
 func (w *RotateWriter) doRotate(suffix string) error {
-	if w.suffix != "" {
-		info, err := os.Stat(w.file)
-		if err == nil && !info.IsDir() {
-			lastFileName := w.file + "." + w.suffix
-			err := os.Rename(w.file, lastFileName)
-			if err != nil {
-				return err
-			}
+	if w.preRotate != nil && w.fp != nil {
+		err := w.preRotate(w.file)
+		if err != nil {
+			return fmt.Errorf("pre rotate hook of %s: %w", w.file, err)
 		}
 	}
-
-	f, err := os.OpenFile(w.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	w.rotationCount.Add(1)
+	if err := w.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "RotateWriter flush error [%v]\n", err)
+	}
+	// the directory may be removed while running, such as by a cleanup script
+	dirPerm := w.dirPerm
+	if dirPerm == 0 {
+		dirPerm = 0755
+	}
+	err := os.MkdirAll(filepath.Dir(w.file), dirPerm)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "RotateWriter open log file error [%v]\n", err)
-		return err
+		return fmt.Errorf("create log directory of %s: %w", w.file, err)
+	}
+
+	var f *os.File
+	if w.atomicRotate && w.suffix != "" {
+		f, err = w.stageRotate(w.file + "." + w.suffix)
+		if err != nil {
+			return err
+		}
+	} else {
+		if w.suffix != "" {
+			info, err := os.Stat(w.file)
+			if err == nil && !info.IsDir() {
+				lastFileName := w.file + "." + w.suffix
+				err := os.Rename(w.file, lastFileName)
+				if err != nil {
+					return fmt.Errorf("rotate log file %s to %s: %w", w.file, lastFileName, err)
+				}
+			}
+		}
+
+		f, err = os.OpenFile(w.file, w.openFlag(), 0666)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "RotateWriter open log file error [%v]\n", err)
+			return fmt.Errorf("open log file %s: %w", w.file, err)
+		}
 	}
 
 	if w.fp != nil {
 		w.fp.Close()
+		// on first open the symlink is updated by NewRotateWriterWithSymlink() to return error
+		if err := w.updateSymlink(); err != nil {
+			fmt.Fprintf(os.Stderr, "RotateWriter update symlink error [%v]\n", err)
+		}
 	}
 	w.fp = f
+	if w.buf != nil {
+		w.buf = bufio.NewWriterSize(f, w.buf.Size())
+	}
 	w.suffix = suffix
+	if w.maxDiskUsage > 0 {
+		if err := w.limitDiskUsage(); err != nil {
+			fmt.Fprintf(os.Stderr, "RotateWriter limit disk usage error [%v]\n", err)
+		}
+	}
 	return nil
 }
+
+// Open the staging file, link the active file to lastFileName and rename the staging file to the active file,
+// so the active file always exists for readers during rotation.
+func (w *RotateWriter) stageRotate(lastFileName string) (*os.File, error) {
+	staging := w.stagingPath
+	if staging == "" {
+		staging = w.file + ".new"
+	}
+	f, err := os.OpenFile(staging, w.openFlag()|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("open staging log file %s: %w", staging, err)
+	}
+	info, err := os.Stat(w.file)
+	if err == nil && !info.IsDir() {
+		// same as os.Rename() replacing an existing file
+		os.Remove(lastFileName)
+		err = os.Link(w.file, lastFileName)
+		if err != nil {
+			f.Close()
+			os.Remove(staging)
+			return nil, fmt.Errorf("rotate log file %s to %s: %w", w.file, lastFileName, err)
+		}
+	}
+	err = os.Rename(staging, w.file)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("rename staging log file %s to %s: %w", staging, w.file, err)
+	}
+	return f, nil
+}
+
+// Write logs to a file without rotation, such as the file rotated by logrotate externally
+type FileOutput struct {
+	fp *os.File
+}
+
+// Create a new FileOutput, the file is opened for append immediately.
+func NewFileOutput(path string) (*FileOutput, error) {
+	fp, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("open log file %s: %w", path, err)
+	}
+	return &FileOutput{fp: fp}, nil
+}
+
+// Returns "FileOutput:" with the log file path
+func (f *FileOutput) String() string {
+	return "FileOutput:" + f.fp.Name()
+}
+
+func (f *FileOutput) Write(msg []byte, level LogLevel) {
+	f.fp.Write(msg)
+}
+
+// Close the file, it should be removed from the Logger before closed.
+func (f *FileOutput) Close() error {
+	return f.fp.Close()
+}
+
+// Open file in append mode and write zero bytes, the modification time of an existing file is not changed
+func checkWritable(file string) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err == nil {
+		_, err = f.Write(nil)
+		f.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("log file %s not writable: %w", file, err)
+	}
+	return nil
+}
+
+// Create symlink at a temp path then rename to w.link, so that w.link always exists
+func (w *RotateWriter) updateSymlink() error {
+	if w.link == "" {
+		return nil
+	}
+	target, err := filepath.Abs(w.file)
+	if err != nil {
+		return err
+	}
+	tmp := w.link + ".tmp"
+	os.Remove(tmp)
+	err = os.Symlink(target, tmp)
+	if errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.link)
+}
+
+// year and ISO week encoded as year*100 + week, used as rotateFlag of RotateByWeek
+func weekFlag(t time.Time) int {
+	year, week := t.ISOWeek()
+	return year*100 + week
+}
+
+func weekSuffix(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf(format_time_week, year, week)
+}
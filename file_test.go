@@ -0,0 +1,79 @@
+package golog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWeekSuffixYearBoundary(t *testing.T) {
+	cases := []struct {
+		day    string
+		suffix string
+	}{
+		{"2026-12-27", "2026-W52"},
+		{"2026-12-28", "2026-W53"},
+		{"2026-12-31", "2026-W53"},
+		{"2027-01-01", "2026-W53"},
+		{"2027-01-03", "2026-W53"},
+		{"2027-01-04", "2027-W01"},
+		{"2024-12-30", "2025-W01"},
+	}
+	for _, c := range cases {
+		day, _ := time.ParseInLocation("2006-01-02", c.day, time.Local)
+		if s := weekSuffix(day); s != c.suffix {
+			t.Errorf("unexpected suffix of %s [%s], expect [%s]", c.day, s, c.suffix)
+		}
+	}
+	last, _ := time.ParseInLocation("2006-01-02", "2027-01-03", time.Local)
+	if weekFlag(last) == weekFlag(last.AddDate(0, 0, 1)) {
+		t.Errorf("week flag not changed from %v", last)
+	}
+	if weekFlag(last) != weekFlag(last.AddDate(0, 0, -6)) {
+		t.Errorf("week flag changed in week 2026-W53")
+	}
+}
+
+func TestRotateByWeekStaleFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(file, []byte("last year\n"), 0644)
+	// 2021-01-03 is in ISO week 2020-W53, a past date is used since a file modified in the future is kept
+	modTime, _ := time.ParseInLocation("2006-01-02 15:04", "2021-01-03 23:30", time.Local)
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewRotateWriter(file, RotateByWeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("this week\n"), LevelInfo)
+	data, _ := os.ReadFile(file + ".2020-W53")
+	if string(data) != "last year\n" {
+		t.Fatalf("unexpected stale file content [%s]", data)
+	}
+	if data, _ = os.ReadFile(file); string(data) != "this week\n" {
+		t.Fatalf("unexpected content [%s]", data)
+	}
+}
+
+func TestSyncWriteFlag(t *testing.T) {
+	w, err := NewRotateWriter(filepath.Join(t.TempDir(), "app.log"), RotateNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.openFlag()&os.O_SYNC != 0 {
+		t.Fatal("O_SYNC set by default")
+	}
+	fp := w.fp
+	if err := w.SetSyncWrite(true); err != nil {
+		t.Fatal(err)
+	}
+	if w.openFlag()&os.O_SYNC == 0 || w.fp == fp {
+		t.Fatal("log file not reopened with O_SYNC")
+	}
+	w.SetSyncWrite(false)
+	if w.openFlag()&os.O_SYNC != 0 {
+		t.Fatal("O_SYNC not cleared")
+	}
+}
@@ -0,0 +1,63 @@
+//go:build unix
+
+package golog_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	log "github.com/thinkphoebe/golog"
+)
+
+// Writes to a fifo block if the pipe is full and not read, like a stalled NFS
+func TestRotateWriterWriteTimeoutStalled(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.fifo")
+	if err := syscall.Mkfifo(file, 0666); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	r, err := os.OpenFile(file, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w, err := log.NewRotateWriter(file, log.RotateNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWriteTimeout(50 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// larger than the pipe buffer, the write blocks until read
+	big := bytes.Repeat([]byte("x"), 1<<20)
+	start := time.Now()
+	w.Write(big, log.LevelInfo)
+	if d := time.Since(start); d > time.Second || w.DroppedCount() != 1 || w.Stat().DroppedCount != 1 {
+		t.Fatalf("unexpected write time %v, dropped %d", d, w.DroppedCount())
+	}
+
+	// drain the pipe so the background write finishes, the fifo is reopened on the next write
+	drained := 0
+	for drained < len(big) {
+		buf := make([]byte, 64<<10)
+		n, err := r.Read(buf)
+		if err != nil && err != syscall.EAGAIN && err != io.EOF {
+			t.Fatal(err)
+		}
+		drained += n
+		if n == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	w.Write([]byte("after stall\n"), log.LevelInfo)
+	buf := make([]byte, 64)
+	n, _ := r.Read(buf)
+	if string(buf[:n]) != "after stall\n" || w.DroppedCount() != 1 {
+		t.Fatalf("unexpected read after reopen [%s], dropped %d", buf[:n], w.DroppedCount())
+	}
+}
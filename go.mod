@@ -1 +1,3 @@
 module github.com/thinkphoebe/golog
+
+go 1.20
@@ -0,0 +1,57 @@
+// Package gologtest provides golog loggers for unit tests, kept apart to not link package testing into programs.
+package gologtest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/thinkphoebe/golog"
+)
+
+const format = "%(asctime) [%(levelno)][%(filename):%(function):%(lineno)] "
+
+// MemoryWriter fails the test on logs of failLevel or above
+type testWriter struct {
+	*golog.MemoryWriter
+	t         testing.TB
+	failLevel golog.LogLevel
+}
+
+func (w *testWriter) Write(msg []byte, level golog.LogLevel) {
+	w.MemoryWriter.Write(msg, level)
+	if level >= w.failLevel {
+		w.t.Helper()
+		w.t.Errorf("unexpected log of level %s: %s", golog.LevelTag(level), msg)
+	}
+}
+
+// *golog.Logger -> *testWriter of loggers created by NewTestLogger()
+var writers sync.Map
+
+// Create a Logger for unit tests, logs are kept in a MemoryWriter and t fails on logs of failLevel or above.
+// The Logger is closed on the test finished.
+func NewTestLogger(t testing.TB, failLevel golog.LogLevel) *golog.Logger {
+	w := &testWriter{MemoryWriter: golog.NewMemoryWriter(), t: t, failLevel: failLevel}
+	l, _ := golog.NewLogger(w, golog.LevelTrace, format, false)
+	writers.Store(l, w)
+	t.Cleanup(func() {
+		writers.Delete(l)
+		l.Close()
+	})
+	return l
+}
+
+// Fail t if l created by NewTestLogger() has logs of LevelError or above
+func AssertNoErrors(t testing.TB, l *golog.Logger) {
+	t.Helper()
+	v, ok := writers.Load(l)
+	if !ok {
+		t.Errorf("logger not created by NewTestLogger")
+		return
+	}
+	for _, e := range v.(*testWriter).Entries() {
+		if e.Level >= golog.LevelError {
+			t.Errorf("error log found: %s", e.Msg)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package gologtest_test
+
+import (
+	"fmt"
+	"testing"
+
+	log "github.com/thinkphoebe/golog"
+	"github.com/thinkphoebe/golog/gologtest"
+)
+
+type recordTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordTB) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestNewTestLogger(t *testing.T) {
+	tb := &recordTB{TB: t}
+	logger := gologtest.NewTestLogger(tb, log.LevelWarn)
+	logger.Infof("not failed")
+	gologtest.AssertNoErrors(tb, logger)
+	if len(tb.errors) != 0 {
+		t.Fatalf("unexpected errors %q", tb.errors)
+	}
+	logger.Errorf("failed")
+	gologtest.AssertNoErrors(tb, logger)
+	if len(tb.errors) != 2 {
+		t.Fatalf("unexpected errors %q", tb.errors)
+	}
+}
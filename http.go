@@ -0,0 +1,103 @@
+package golog
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Start a http server for log management:
+//
+//	GET  /level    get current level, such as "info"
+//	PUT  /level    set level by request body, such as "debug"
+//	GET  /logs?n=N get the last N logs, a RingBufferOutput is added for it
+//	POST /rotate   rotate all Rotatable outputs, see Logger.Rotate()
+//
+// No lock, callers should not start or stop it from multi-goroutines.
+func (l *Logger) StartHTTPServer(addr string) error {
+	if l.httpServer != nil {
+		return errors.New("http server already started")
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/level", l.handleLevel)
+	mux.HandleFunc("/logs", l.handleLogs)
+	mux.HandleFunc("/rotate", l.handleRotate)
+
+	l.httpRing = NewRingBufferOutput(defaultRingSize)
+	l.AddOutput(l.httpRing)
+	l.httpServer = &http.Server{Handler: mux}
+	go l.httpServer.Serve(ln)
+	return nil
+}
+
+// Stop the http server started by StartHTTPServer()
+func (l *Logger) StopHTTPServer() error {
+	if l.httpServer == nil {
+		return errors.New("http server not started")
+	}
+	err := l.httpServer.Close()
+	l.RemoveOutput(l.httpRing)
+	l.httpServer = nil
+	l.httpRing = nil
+	return err
+}
+
+func (l *Logger) handleLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Write([]byte(levelNames[levelIndex(l.Level())]))
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		level, err := parseLevel(strings.TrimSpace(string(body)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		l.SetLevel(level)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (l *Logger) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	n := 0
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, line := range l.httpRing.Lines(n) {
+		w.Write(line)
+	}
+}
+
+func (l *Logger) handleRotate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := l.Rotate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,85 @@
+package golog
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTTPServer(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	w, err := NewRotateWriter(file, RotateNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, _ := NewLogger(w, LevelInfo, "[%(levelno)] ", false)
+	if err := l.StartHTTPServer("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.StartHTTPServer("127.0.0.1:0"); err == nil {
+		t.Fatal("expected error on starting twice")
+	}
+	// serve the handler of the started server, the listening address is not exported
+	srv := httptest.NewServer(l.httpServer.Handler)
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, string) {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	if code, body := do(http.MethodGet, "/level", ""); code != http.StatusOK || body != "info" {
+		t.Fatalf("unexpected level response %d [%s]", code, body)
+	}
+	if code, _ := do(http.MethodPut, "/level", "debug\n"); code != http.StatusOK || l.Level() != LevelDebug {
+		t.Fatalf("unexpected set level response %d, level %d", code, l.Level())
+	}
+	if code, _ := do(http.MethodPut, "/level", "verbose"); code != http.StatusBadRequest || l.Level() != LevelDebug {
+		t.Fatalf("unexpected invalid level response %d, level %d", code, l.Level())
+	}
+	if code, _ := do(http.MethodPost, "/level", "info"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected method response %d", code)
+	}
+
+	l.Infof("first")
+	l.Debugf("second")
+	l.Warnf("third")
+	if code, body := do(http.MethodGet, "/logs?n=2", ""); code != http.StatusOK || body != "[D] second\n[W] third\n" {
+		t.Fatalf("unexpected logs response %d [%s]", code, body)
+	}
+	if code, body := do(http.MethodGet, "/logs", ""); code != http.StatusOK || body != "[I] first\n[D] second\n[W] third\n" {
+		t.Fatalf("unexpected logs response %d [%s]", code, body)
+	}
+	if code, _ := do(http.MethodGet, "/logs?n=two", ""); code != http.StatusBadRequest {
+		t.Fatalf("unexpected invalid n response %d", code)
+	}
+
+	if code, _ := do(http.MethodGet, "/rotate", ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected method response %d", code)
+	}
+	if code, body := do(http.MethodPost, "/rotate", ""); code != http.StatusOK {
+		t.Fatalf("unexpected rotate response %d [%s]", code, body)
+	}
+	if files, _ := filepath.Glob(file + ".*"); len(files) != 1 {
+		t.Fatalf("unexpected rotated files %v", files)
+	}
+
+	if err := l.StopHTTPServer(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.StopHTTPServer(); err == nil {
+		t.Fatal("expected error on stopping twice")
+	}
+	if len(l.outs) != 1 {
+		t.Fatalf("ring buffer output not removed, %d outputs", len(l.outs))
+	}
+}
@@ -3,34 +3,45 @@ package golog
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
+	"unicode/utf8"
 )
 
 type LogLevel int
 
-type logItem struct {
-	level     LogLevel
-	filename  string
-	function  string
-	line      int
-	calldepth int
+// Information of a log passed to HeaderSession.GenHeader
+type LogItem struct {
+	Level     LogLevel
+	Filename  string // empty before caller info initialized
+	Function  string
+	Line      int
+	Calldepth int        // caller of the log is runtime.Caller(Calldepth) in GenHeader
+	Time      *time.Time // not nil -> time of the log instead of time.Now(), set by OutputAt()
 }
 
-type genHeaderFunc func(buf *[]byte, item *logItem)
-
-type headerSession struct {
-	name      string // used as key of json output
-	isCopy    bool
-	strCopy   string
-	genHeader genHeaderFunc
+// A segment of log header, either a string const or generated by GenHeader
+type HeaderSession struct {
+	Name      string // used as key of json output
+	IsCopy    bool
+	StrCopy   string
+	GenHeader func(buf *[]byte, item *LogItem)
 }
 
 type outWriter struct {
@@ -39,33 +50,142 @@ type outWriter struct {
 }
 
 type outItem struct {
-	msg   []byte
-	level LogLevel
+	msg      []byte
+	level    LogLevel
+	writer   IOutput         // not nil -> replace writer of the outWriter, msg ignored
+	flush    *sync.WaitGroup // not nil -> Done() after all previous logs written, msg ignored
+	call     func(w IOutput) // not nil -> called with writer of each outWriter before flush Done(), msg ignored
+	banner   bool            // true -> msg is a checkpoint banner
+	progress bool            // true -> msg is a progress line
+	group    []*outItem      // not nil -> items written contiguously, level is the highest of them, msg ignored
+	queued   int64           // UnixNano on enqueued to chOut, set if queue age metrics enabled
+	cmd      *cmdItem        // not nil -> applied by copyRoutine in order with logs, msg ignored
 }
 
 type cmdItem struct {
-	cmd   int         // 0 -> add outWriter, 1 -> remove outWriter
-	param interface{} // 0, 1 -> IOutput
+	cmd   int         // 0 -> add outWriter, 1 -> remove outWriter, 2 -> swap outWriter, 3 -> set outWriter, 4 -> set level output
+	param interface{} // 0, 1, 3 -> IOutput, 2 -> *swapParam, 4 -> *levelOutputParam
+}
+
+type levelOutputParam struct {
+	level LogLevel
+	w     IOutput
+}
+
+type swapParam struct {
+	old     IOutput
+	new     IOutput
+	chFound chan bool
 }
 
 type Json map[string]interface{}
 
+// Implemented by outputs render checkpoint banners differently, such as ConsoleWriter
+type bannerWriter interface {
+	writeBanner(msg []byte, level LogLevel)
+}
+
+// Implemented by outputs rewrite progress lines in place, such as ConsoleWriter
+type progressWriter interface {
+	writeProgress(msg []byte, level LogLevel)
+}
+
+// Implemented by outputs need to know the header format, such as ConsoleWriter
+type headerSessionSetter interface {
+	setHeaderSessions(sessions []HeaderSession)
+}
+
+// Implemented by outputs buffer writes, such as RotateWriter with SetBufferSize(). Flushed by the flush of
+// Logger such as FlushTimeout(), on Close() and after Critical and Emergency logs.
+type flusher interface {
+	Flush() error
+}
+
+func flushOutput(w IOutput) {
+	if f, ok := w.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "golog flush %v error [%v]\n", w, err)
+		}
+	}
+}
+
 // Output interface of Logger. Users can implement this interface to output to other destinations such as udp.
 type IOutput interface {
 	Write(msg []byte, level LogLevel)
 }
 
+// Wrap a function as IOutput. Use pointer of FuncOutput for RemoveOutput() since functions are not comparable.
+type FuncOutput struct {
+	fn func(msg []byte, level LogLevel)
+}
+
+func NewFuncOutput(fn func(msg []byte, level LogLevel)) *FuncOutput {
+	return &FuncOutput{fn: fn}
+}
+
+func (f *FuncOutput) Write(msg []byte, level LogLevel) {
+	f.fn(msg, level)
+}
+
 // A Logger represents an active logging object that generates lines of
 // output to an IOutput. A Logger can be used simultaneously from
 // multiple goroutines; it guarantees to serialize access to the Writer.
+//
+// Child loggers such as created by FromContext() and Wrap() use settings of the parent not set on themselves,
+// including the critical action, default fields, contexts, trace packages, json format, field order and template.
 type Logger struct {
+	*loggerCore
+	parent         *Logger // not nil for child loggers, such as created by FromContext()
+	level          LogLevel
+	suppressUntil  time.Time
+	suppressLevel  LogLevel
+	criticalAction func()
+	ctxFields      Json     // added to json logs, set by FromContext()
+	defaultFields  Json     // added to json logs, set by SetDefaultFields()
+	prefix         string   // written before messages, set by Wrap()
+	scopeDepth     int      // indent level of loggers returned by Scope()
+	jsonFormat     int8     // 1 -> OutputJson() outputs like OutputPrettyJson(), -1 -> compact, 0 -> same as parent
+	fieldOrder     []string // keys output first in json logs, set by SetFieldOrder()
+	tracePatterns  []string // Trace logs are output only from files match these patterns if set
+	watchStop      chan struct{}
+	tmpl           *template.Template // used by Templatef(), set by SetDefaultTemplate()
+
+	contexts atomic.Value // []dynContext, replaced by AddContext() and RemoveContext()
+}
+
+// Outputs and header format shared by a Logger and its children
+type loggerCore struct {
+	queueWait      int64 // nanoseconds the last log copied by copyRoutine waited in chOut, first for 64-bit alignment
+	droppedLogs    int64 // logs dropped since last overflow callback
+	lastOverflow   int64 // UnixNano of last overflow callback
+	overflowGap    int64 // min nanoseconds between overflow callbacks, set by SetAsyncOverflowThrottle()
+	asctimeTTL     int64 // nanoseconds to reuse the asctime header, set by SetHeaderCacheTime()
 	mu             sync.Mutex
+	asyncMu        sync.RWMutex // locked for read to use async and the channels, locked by SetAsync()
+	outRoutines    sync.WaitGroup
 	outs           []outWriter
-	level          LogLevel
 	async          bool
 	chOut          chan *outItem
 	chCmd          chan *cmdItem
-	headerSessions []headerSession
+	chDone         chan struct{} // closed on copyRoutine exit
+	headerSessions atomic.Value  // []HeaderSession, replaced by SetHeaderSessions()
+	format         string        // header format of NewLogger(), empty if replaced by SetHeaderSessions()
+	levelOuts      [numLevels]IOutput
+	numOuts        int32 // len(outs) for reading without lock
+	queueAge       int32 // 1 -> record enqueue time of logs for Metrics()
+	closed         int32 // 1 -> logs are dropped, set if FlushTimeout() timed out
+	droppedLevel   int32 // level of the last dropped log
+	overflowTimer  int32 // 1 -> pending drops will be reported after the throttle
+	httpServer     *http.Server
+	httpRing       *RingBufferOutput
+	exportOut      *exportOutput
+	scopeIndent    int32
+	maxMessageSize int          // <= 0 -> not limited, set by SetMaxMessageSize()
+	overflowFn     atomic.Value // func(level LogLevel, dropped int), set by SetAsyncOverflowCallback()
+	noCaller       bool         // caller fields of header are empty, set by WithCallerInfo(false)
+	sinks          atomic.Value // []*channelOutput, replaced by SinkChannel()
+	asctimeCache   atomic.Value // *cachedAsctime, see SetHeaderCacheTime()
+	afterN         sync.Map     // format -> *int64 occurrences, see OutputAfterN()
 }
 
 // Users can redirect an os.File to log by AddRedirect(). AddRedirect() returns a Redirector for CancelRedirect().
@@ -77,11 +197,13 @@ type Redirector struct {
 }
 
 const (
-	LevelDebug LogLevel = iota
+	LevelTrace LogLevel = -1 // below LevelDebug, the values of other levels are kept
+	LevelDebug LogLevel = iota - 1
 	LevelInfo
 	LevelWarn
 	LevelError
 	LevelCritical
+	LevelEmergency // system unusable, the same as emergency severity of syslog
 )
 
 // Parameter calldepth is used to recover the PC for file name and line no print.
@@ -90,47 +212,134 @@ const NormalDepth = 2
 const AsyncBuffer = 1000
 const OutputBuffer = 10000
 
-var levels = [...]string{int(LevelDebug): "D", int(LevelInfo): "I", int(LevelWarn): "W", int(LevelError): "E", int(LevelCritical): "C"}
+// Number of levels, arrays of levels are indexed by levelIndex()
+const numLevels = int(LevelEmergency-LevelTrace) + 1
+
+func levelIndex(level LogLevel) int {
+	return int(level - LevelTrace)
+}
+
+var levels = [numLevels]string{"T", "D", "I", "W", "E", "C", "M"}
+var levelNames = [numLevels]string{"trace", "debug", "info", "warn", "error", "critical", "emergency"}
 
-func NewLogger(out IOutput, level LogLevel, fmtStr string, async bool) (*Logger, error) {
+func NewLogger(out IOutput, level LogLevel, fmtStr string, async bool, opts ...LoggerOption) (*Logger, error) {
 	l := &Logger{
-		level: level,
-		async: async,
+		loggerCore: &loggerCore{overflowGap: int64(time.Second)},
+		level:      level,
+	}
+	for _, opt := range opts {
+		opt(l)
 	}
 	err := l.setHeaderFormat(fmtStr)
 	if err != nil {
 		l = nil
 	} else {
+		// add before copyRoutine started, or the first logs may be copied before the output added
+		l.addOutput(out)
 		if async {
-			l.chOut = make(chan *outItem, AsyncBuffer)
-			l.chCmd = make(chan *cmdItem, 100)
-			go l.copyRoutine()
+			l.startAsync()
 		}
-
-		l.AddOutput(out)
 	}
 	return l, err
 }
 
-// By default, log level is printed as 'D', 'I', 'W', 'E' and 'C', you could modify them by SetLevelTag().
+// Optional settings of NewLogger()
+type LoggerOption func(l *Logger)
+
+// Set false to output caller fields of header as empty strings, such as "filename", "function" and "lineno",
+// runtime.Caller() is never called for hot paths. True by default.
+func WithCallerInfo(enabled bool) LoggerOption {
+	return func(l *Logger) {
+		l.noCaller = !enabled
+	}
+}
+
+func (l *Logger) startAsync() {
+	l.chOut = make(chan *outItem, AsyncBuffer)
+	l.chCmd = make(chan *cmdItem, 100)
+	l.chDone = make(chan struct{})
+	for i := range l.outs {
+		warnSyncWrite(l.outs[i].writer)
+		l.outs[i].chIn = make(chan *outItem, OutputBuffer)
+		out := l.outs[i]
+		l.outRoutines.Add(1)
+		go l.outputRoutine(&out)
+	}
+	l.async = true
+	go l.copyRoutine()
+}
+
+// Switch between async and sync mode. On switching to sync, all queued logs are written before return,
+// or returns error of ctx and keeps async if not completed before ctx done.
+// Logs of other goroutines wait until switched.
+func (l *Logger) SetAsync(ctx context.Context, async bool) error {
+	l.asyncMu.Lock()
+	defer l.asyncMu.Unlock()
+	if async == l.async {
+		return nil
+	}
+	if async {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.startAsync()
+		return nil
+	}
+
+	err := l.flushContext(ctx)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	close(l.chCmd)
+	close(l.chOut)
+	<-l.chDone
+	// outputs are written by the sync logger after the output goroutines exited
+	l.outRoutines.Wait()
+	for i := range l.outs {
+		l.outs[i].chIn = nil
+	}
+	l.chOut = nil
+	l.chCmd = nil
+	l.async = false
+	return nil
+}
+
+// By default, log level is printed as 'T', 'D', 'I', 'W', 'E' and 'C', you could modify them by SetLevelTag().
 func SetLevelTag(level LogLevel, name string) {
-	levels[level] = name
+	levels[levelIndex(level)] = name
 }
 
 func LevelTag(level LogLevel) string {
-	return levels[level]
+	return levels[levelIndex(level)]
 }
 
 func TagLevel(name string) LogLevel {
 	for i, v := range levels {
 		if v == name {
-			return LogLevel(i)
+			return LogLevel(i) + LevelTrace
 		}
 	}
 	return LevelDebug
 }
 
+// Parse level names such as "debug", "info", "warn", "error" and "critical", case insensitive
+func parseLevel(name string) (LogLevel, error) {
+	for i, v := range levelNames {
+		if strings.EqualFold(v, name) {
+			return LogLevel(i) + LevelTrace, nil
+		}
+	}
+	return LevelDebug, fmt.Errorf("unknown level [%s]", name)
+}
+
+// For child loggers, returns level of the parent if it is higher.
 func (l *Logger) Level() LogLevel {
+	if l.parent != nil {
+		if level := l.parent.Level(); level > l.level {
+			return level
+		}
+	}
 	return l.level
 }
 
@@ -140,52 +349,221 @@ func (l *Logger) SetLevel(level LogLevel) {
 	l.level = level
 }
 
+// Drop all logs in the next duration d, such as the noisy logs on program start.
+func (l *Logger) SuppressUntil(d time.Duration) {
+	l.SuppressLevel(LevelEmergency+1, d)
+}
+
+// Drop logs below level in the next duration d. Logs of level and above are still output.
+func (l *Logger) SuppressLevel(level LogLevel, d time.Duration) {
+	//SuppressLevel is not locked, same as SetLevel
+	l.suppressLevel = level
+	l.suppressUntil = time.Now().Add(d)
+}
+
+// Returns whether logs of level will be output, considering level, suppression and parent logger
+func (l *Logger) Enabled(level LogLevel) bool {
+	return l.enabled(level)
+}
+
+func (l *Logger) enabled(level LogLevel) bool {
+	if level < l.level {
+		return false
+	}
+	if !l.suppressUntil.IsZero() && level < l.suppressLevel && time.Now().Before(l.suppressUntil) {
+		return false
+	}
+	if l.parent != nil {
+		return l.parent.enabled(level)
+	}
+	return true
+}
+
 // copy logs from chOut to chIn of each outWriter
 func (l *Logger) copyRoutine() {
+	defer close(l.chDone)
+	chOut := l.chOut
+	chCmd := l.chCmd
 	for {
 		select {
-		case item := <-l.chOut:
+		case item, ok := <-chOut:
+			if !ok {
+				// closed by Close(), logs before are all copied
+				for _, out := range l.outs {
+					close(out.chIn)
+				}
+				return
+			}
+			if item.cmd != nil {
+				l.doCmd(item.cmd)
+				break
+			}
+			if item.queued != 0 {
+				atomic.StoreInt64(&l.queueWait, time.Now().UnixNano()-item.queued)
+			}
+			if item.flush != nil {
+				item.flush.Add(len(l.outs))
+				item.flush.Done()
+			}
 			for _, out := range l.outs {
 				out.chIn <- item
 			}
-		case cmd, ok := <-l.chCmd:
+			// ATTENTION level outputs of async logger are written in copyRoutine
+			l.writeLevelOut(item)
+		case cmd, ok := <-chCmd:
 			if !ok {
+				chCmd = nil
 				break
 			}
-			if cmd.cmd == 0 {
-				l.addOutput(cmd.param.(IOutput))
-			} else if cmd.cmd == 1 {
-				l.removeOutput(cmd.param.(IOutput))
-			}
+			l.doCmd(cmd)
 		}
 	}
 }
 
+// Called by copyRoutine
+func (l *Logger) doCmd(cmd *cmdItem) {
+	if cmd.cmd == 0 {
+		l.addOutput(cmd.param.(IOutput))
+	} else if cmd.cmd == 1 {
+		l.removeOutput(cmd.param.(IOutput))
+	} else if cmd.cmd == 2 {
+		p := cmd.param.(*swapParam)
+		p.chFound <- l.swapOutput(p.old, p.new)
+	} else if cmd.cmd == 3 {
+		l.setOutput(cmd.param.(IOutput))
+	} else if cmd.cmd == 4 {
+		p := cmd.param.(*levelOutputParam)
+		l.levelOuts[levelIndex(p.level)] = p.w
+	}
+}
+
 func (l *Logger) outputRoutine(out *outWriter) {
+	defer l.outRoutines.Done()
 	for {
 		item, ok := <-out.chIn
 		if !ok {
+			flushOutput(out.writer)
 			break
 		}
+		if item.writer != nil {
+			out.writer = item.writer
+			continue
+		}
+		if item.call != nil {
+			item.call(out.writer)
+		}
+		if item.flush != nil {
+			item.flush.Done()
+			continue
+		}
 		if len(out.chIn) > OutputBuffer*3/5 && item.level <= LevelDebug ||
 			len(out.chIn) > OutputBuffer*4/5 && item.level <= LevelInfo {
+			l.onDropped(item.level)
 			continue
 		}
-		out.writer.Write(item.msg, item.level)
+		writeOut(out.writer, item)
+	}
+}
+
+// Set fn called on logs dropped by async logger, Debug and Info logs are dropped if an output falls behind.
+// fn is called in the output goroutine with level of the dropped log and logs dropped since the last call,
+// at most once per throttle of SetAsyncOverflowThrottle(). Logs dropped in the throttle are reported by a
+// timer goroutine at the end of it if no more drops. It should not block or log to the same logger.
+func (l *Logger) SetAsyncOverflowCallback(fn func(level LogLevel, dropped int)) {
+	l.overflowFn.Store(fn)
+}
+
+// Set the min interval between calls of the callback of SetAsyncOverflowCallback(), 1 second by default.
+func (l *Logger) SetAsyncOverflowThrottle(d time.Duration) {
+	atomic.StoreInt64(&l.overflowGap, int64(d))
+}
+
+func (l *Logger) onDropped(level LogLevel) {
+	atomic.AddInt64(&l.droppedLogs, 1)
+	atomic.StoreInt32(&l.droppedLevel, int32(level))
+	fn, _ := l.overflowFn.Load().(func(level LogLevel, dropped int))
+	if fn == nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&l.lastOverflow)
+	gap := atomic.LoadInt64(&l.overflowGap)
+	if now-last < gap {
+		// report the drops in the throttle at the end of it, drops may stop before the next callback
+		if atomic.CompareAndSwapInt32(&l.overflowTimer, 0, 1) {
+			time.AfterFunc(time.Duration(last+gap-now), l.reportDropped)
+		}
+		return
+	}
+	if !atomic.CompareAndSwapInt64(&l.lastOverflow, last, now) {
+		return
+	}
+	fn(level, int(atomic.SwapInt64(&l.droppedLogs, 0)))
+}
+
+// Called by the timer of onDropped()
+func (l *Logger) reportDropped() {
+	atomic.StoreInt32(&l.overflowTimer, 0)
+	dropped := atomic.SwapInt64(&l.droppedLogs, 0)
+	if dropped == 0 {
+		return
+	}
+	atomic.StoreInt64(&l.lastOverflow, time.Now().UnixNano())
+	fn, _ := l.overflowFn.Load().(func(level LogLevel, dropped int))
+	if fn == nil {
+		return
+	}
+	fn(LogLevel(atomic.LoadInt32(&l.droppedLevel)), int(dropped))
+}
+
+func writeOut(w IOutput, item *outItem) {
+	if item.group != nil {
+		for _, sub := range item.group {
+			writeOut(w, sub)
+		}
+		return
+	}
+	if item.banner {
+		if b, ok := w.(bannerWriter); ok {
+			b.writeBanner(item.msg, item.level)
+			return
+		}
+	}
+	if item.progress {
+		if p, ok := w.(progressWriter); ok {
+			p.writeProgress(item.msg, item.level)
+			return
+		}
 	}
+	w.Write(item.msg, item.level)
 }
 
 func (l *Logger) addOutput(w IOutput) {
+	if h, ok := w.(headerSessionSetter); ok {
+		h.setHeaderSessions(l.getHeaderSessions())
+	}
 	out := outWriter{writer: w}
 	if l.async {
+		warnSyncWrite(w)
 		out.chIn = make(chan *outItem, OutputBuffer)
+		l.outRoutines.Add(1)
 		go l.outputRoutine(&out)
 	}
 	l.outs = append(l.outs, out)
+	atomic.StoreInt32(&l.numOuts, int32(len(l.outs)))
+}
+
+// O_SYNC not guarantees logs queued by async loggers written on crash
+func warnSyncWrite(w IOutput) {
+	if r, ok := w.(*RotateWriter); ok && r.syncWrite {
+		fmt.Fprintf(os.Stderr, "golog: sync write of %s is less meaningful with async logger\n", r.file)
+	}
 }
 
 // Add an outWriter to write. You can add more than one outWriter.
 func (l *Logger) AddOutput(w IOutput) {
+	l.asyncMu.RLock()
+	defer l.asyncMu.RUnlock()
 	if l.async {
 		l.chCmd <- &cmdItem{cmd: 0, param: w}
 	} else {
@@ -195,10 +573,18 @@ func (l *Logger) AddOutput(w IOutput) {
 	}
 }
 
+// Add a function as output. The returned FuncOutput can be passed to RemoveOutput().
+func (l *Logger) AddOutputFunc(fn func(msg []byte, level LogLevel)) *FuncOutput {
+	f := NewFuncOutput(fn)
+	l.AddOutput(f)
+	return f
+}
+
 func (l *Logger) removeOutput(w IOutput) {
 	for i, v := range l.outs {
 		if v.writer == w {
 			l.outs = append(l.outs[:i], l.outs[i+1:]...)
+			atomic.StoreInt32(&l.numOuts, int32(len(l.outs)))
 			if l.async {
 				close(v.chIn)
 			}
@@ -207,6 +593,8 @@ func (l *Logger) removeOutput(w IOutput) {
 }
 
 func (l *Logger) RemoveOutput(w IOutput) {
+	l.asyncMu.RLock()
+	defer l.asyncMu.RUnlock()
 	if l.async {
 		l.chCmd <- &cmdItem{cmd: 1, param: w}
 	} else {
@@ -216,6 +604,74 @@ func (l *Logger) RemoveOutput(w IOutput) {
 	}
 }
 
+// Write logs of level to w in addition to the outputs, such as write errors to a separate file.
+// An IOutput can be set for multiple levels, set nil to remove.
+func (l *Logger) SetLevelOutput(level LogLevel, w IOutput) {
+	l.asyncMu.RLock()
+	defer l.asyncMu.RUnlock()
+	if l.async {
+		l.chCmd <- &cmdItem{cmd: 4, param: &levelOutputParam{level: level, w: w}}
+	} else {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.levelOuts[levelIndex(level)] = w
+	}
+}
+
+func (l *Logger) setOutput(w IOutput) {
+	if l.async {
+		for _, v := range l.outs {
+			close(v.chIn)
+		}
+	}
+	l.outs = nil
+	l.addOutput(w)
+}
+
+// Remove all outputs and set w as the only output, like SetOutput() of the standard log package.
+// For async loggers, logs queued before are still written to the old outputs, and logs after to w.
+func (l *Logger) SetOutput(w IOutput) {
+	l.asyncMu.RLock()
+	defer l.asyncMu.RUnlock()
+	if l.async {
+		// sent through chOut rather than chCmd, which is not ordered with logs
+		l.chOut <- &outItem{cmd: &cmdItem{cmd: 3, param: w}}
+	} else {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.setOutput(w)
+	}
+}
+
+func (l *Logger) swapOutput(old IOutput, new IOutput) bool {
+	for i, v := range l.outs {
+		if v.writer == old {
+			l.outs[i].writer = new
+			if l.async {
+				// queued logs before the swap are still written to the old one
+				v.chIn <- &outItem{writer: new}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Replace output old with new in one step, so that no log is lost between RemoveOutput() and AddOutput().
+// Returns false if old is not found.
+func (l *Logger) SwapOutput(old IOutput, new IOutput) bool {
+	l.asyncMu.RLock()
+	defer l.asyncMu.RUnlock()
+	if l.async {
+		p := &swapParam{old: old, new: new, chFound: make(chan bool, 1)}
+		l.chCmd <- &cmdItem{cmd: 2, param: p}
+		return <-p.chFound
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.swapOutput(old, new)
+}
+
 // Redirect an os.File to log, such as os.stderr.
 func (l *Logger) AddRedirect(file **os.File, level LogLevel, tag string) *Redirector {
 	pr, pw, err := os.Pipe()
@@ -249,49 +705,201 @@ func (l *Logger) CancelRedirect(r *Redirector) {
 	*r.oldAddr = r.old
 }
 
+// Returned by FlushTimeout() if queued logs are not written in time
+var ErrFlushTimeout = errors.New("flush timeout")
+
+// Wait until logs written before are passed to all outputs, for shutdown without blocking forever on a wedged output.
+// Returns ErrFlushTimeout if not completed in d, and the logs written afterwards are dropped.
+// For sync logger buffered outputs are flushed and nil is returned.
+func (l *Logger) FlushTimeout(d time.Duration) error {
+	l.asyncMu.RLock()
+	defer l.asyncMu.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	if l.flushContext(ctx) != nil {
+		atomic.StoreInt32(&l.closed, 1)
+		return ErrFlushTimeout
+	}
+	return nil
+}
+
+// Wait until logs written before are passed to all outputs
+func (l *Logger) flush() {
+	l.asyncMu.RLock()
+	defer l.asyncMu.RUnlock()
+	l.flushContext(context.Background())
+}
+
+// A flush item is sent through chOut to every outputRoutine, wait for all of them Done() or ctx done.
+func (l *Logger) flushContext(ctx context.Context) error {
+	if !l.async {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.flushOutputs()
+		return nil
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	select {
+	case l.chOut <- &outItem{flush: &wg, call: flushOutput}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Call fn with each output, in the output goroutines for async logger. Returns after all called.
+func (l *Logger) callOutputs(fn func(w IOutput)) {
+	l.asyncMu.RLock()
+	defer l.asyncMu.RUnlock()
+	if l.async {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		l.chOut <- &outItem{call: fn, flush: &wg}
+		wg.Wait()
+	} else {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, out := range l.outs {
+			fn(out.writer)
+		}
+	}
+}
+
+// Set colors of levels for all ConsoleWriter outputs, such as colors from config files. Other outputs are skipped.
+// Colors are not set if any of them is not an ANSI escape code like "\033[31;1m".
+func (l *Logger) SetLevelColors(colors map[LogLevel]string) error {
+	for level, brush := range colors {
+		if level < LevelTrace || level > LevelEmergency {
+			return fmt.Errorf("invalid level %d", level)
+		}
+		if !strings.HasPrefix(brush, "\033[") || !strings.HasSuffix(brush, "m") {
+			return fmt.Errorf("invalid brush %q of level %s", brush, levelNames[levelIndex(level)])
+		}
+	}
+	l.callOutputs(func(w IOutput) {
+		if c, ok := w.(*ConsoleWriter); ok {
+			for level, brush := range colors {
+				c.SetBrush(brush, level)
+			}
+		}
+	})
+	return nil
+}
+
+// Implemented by outputs can be rotated by Logger.Rotate(), such as RotateWriter
+type Rotatable interface {
+	Rotate() error
+}
+
+// Returns whether w can be rotated by Logger.Rotate()
+func SupportsRotation(w IOutput) bool {
+	_, ok := w.(Rotatable)
+	return ok
+}
+
+// Rotate all outputs implement Rotatable, such as on SIGHUP received. Returns the first error.
+func (l *Logger) Rotate() error {
+	var mu sync.Mutex
+	var first error
+	l.callOutputs(func(w IOutput) {
+		if r, ok := w.(Rotatable); ok {
+			err := r.Rotate()
+			mu.Lock()
+			if err != nil && first == nil {
+				first = err
+			}
+			mu.Unlock()
+		}
+	})
+	return first
+}
+
 // An async logger should be Close() to avoid resource leak.
 // Before Close() any redirect should be canceled.
+// Buffered outputs are flushed, for async logger by the output goroutines after all logs written.
+// No effect for child loggers, outputs are shared with the parent and closed by it.
 func (l *Logger) Close() {
+	if l.parent != nil {
+		return
+	}
+	l.asyncMu.RLock()
+	defer l.asyncMu.RUnlock()
 	if l.async {
 		close(l.chCmd)
 		close(l.chOut)
-		for _, v := range l.outs {
-			close(v.chIn)
+	} else {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.flushOutputs()
+	}
+}
+
+// Flush outputs and level outputs of sync logger, with l.mu locked
+func (l *Logger) flushOutputs() {
+	for _, out := range l.outs {
+		flushOutput(out.writer)
+	}
+	for _, w := range l.levelOuts {
+		if w != nil {
+			flushOutput(w)
 		}
 	}
 }
 
-// Please ATTENTION that header format is not designed to be modify after logger created.
-// So users can only set header format on NewLogger() called. No lock when Logger.headerSessions used.
+type cachedAsctime struct {
+	header []byte
+	expiry int64 // UnixNano
+}
+
+// Reuse the asctime of header for d to save formatting time on every log, such as 100ms if millisecond
+// precision is unnecessary. Logs in d are output with the same time. 0 to format on every log, by default.
+func (l *Logger) SetHeaderCacheTime(d time.Duration) {
+	atomic.StoreInt64(&l.asctimeTTL, int64(d))
+	l.asctimeCache.Store((*cachedAsctime)(nil))
+}
+
+// Header format is set on NewLogger() called, or replaced by SetHeaderSessions().
 func (l *Logger) setHeaderFormat(fmtStr string) error {
-	initCaller := func(item *logItem) {
+	initCaller := func(item *LogItem) {
 		var ok bool
 		var pc uintptr
-		pc, item.filename, item.line, ok = runtime.Caller(item.calldepth + 1)
+		pc, item.Filename, item.Line, ok = runtime.Caller(item.Calldepth + 1)
 		if ok {
 			var f = runtime.FuncForPC(pc)
 			if f != nil {
-				item.function = f.Name()
+				item.Function = f.Name()
 			} else {
-				item.function = "???"
+				item.Function = "???"
 			}
 		} else {
-			item.filename = "???"
-			item.line = 0
+			item.Filename = "???"
+			item.Line = 0
 		}
 
-		i := strings.LastIndexByte(item.filename, '/')
+		i := strings.LastIndexByte(item.Filename, '/')
 		if i >= 0 {
-			item.filename = item.filename[i+1:]
+			item.Filename = item.Filename[i+1:]
 		}
 
-		i = strings.LastIndexByte(item.function, '/')
+		i = strings.LastIndexByte(item.Function, '/')
 		if i >= 0 {
-			item.function = item.function[i+1:]
+			item.Function = item.Function[i+1:]
 		}
-		i = strings.IndexByte(item.function, '.')
+		i = strings.IndexByte(item.Function, '.')
 		if i >= 0 {
-			item.function = item.function[i+1:]
+			item.Function = item.Function[i+1:]
 		}
 	}
 
@@ -312,21 +920,41 @@ func (l *Logger) setHeaderFormat(fmtStr string) error {
 		return append(b, buf[i:]...)
 	}
 
+	appendAsctime := func(b []byte, now time.Time) []byte {
+		//b = now.AppendFormat(b, "2006-01-02 15:04:05.999")
+		year, mon, day := now.Date()
+		hour, min, sec := now.Clock()
+		nsec := now.Nanosecond()
+		b = appendInt(b, year, 4)
+		b = append(b, []byte("-")...)
+		b = appendInt(b, int(mon), 2)
+		b = append(b, []byte("-")...)
+		b = appendInt(b, day, 2)
+		b = append(b, []byte(" ")...)
+		b = appendInt(b, hour, 2)
+		b = append(b, []byte(":")...)
+		b = appendInt(b, min, 2)
+		b = append(b, []byte(":")...)
+		b = appendInt(b, sec, 2)
+		b = append(b, []byte(".")...)
+		return appendInt(b, nsec/1000000, 3)
+	}
+
 	reg, err := regexp.Compile(`%\([\w\:]+\)`)
 	if err != nil {
 		return err
 	}
 
 	matches := reg.FindAllStringIndex(fmtStr, -1)
-	sessions := make([]headerSession, 0, len(matches))
+	sessions := make([]HeaderSession, 0, len(matches))
 	beg := 0
 	end := 0
 	for _, match := range matches {
 		end = match[0]
 		if end > beg {
-			sessions = append(sessions, headerSession{
-				isCopy:  true,
-				strCopy: fmtStr[beg:end],
+			sessions = append(sessions, HeaderSession{
+				IsCopy:  true,
+				StrCopy: fmtStr[beg:end],
 			})
 		}
 
@@ -337,71 +965,79 @@ func (l *Logger) setHeaderFormat(fmtStr string) error {
 			name = secs[1]
 		}
 
+		if l.noCaller && (secs[0] == "filename" || secs[0] == "function" || secs[0] == "lineno") {
+			sessions = append(sessions, HeaderSession{
+				Name:      name,
+				IsCopy:    false,
+				GenHeader: func(buf *[]byte, item *LogItem) {},
+			})
+			beg = match[1]
+			continue
+		}
+
 		switch secs[0] {
 		case "asctime":
-			sessions = append(sessions, headerSession{
-				name:   name,
-				isCopy: false,
-				genHeader: func(buf *[]byte, item *logItem) {
-					//*buf = time.Now().AppendFormat(*buf, "2006-01-02 15:04:05.999")
+			sessions = append(sessions, HeaderSession{
+				Name:   name,
+				IsCopy: false,
+				GenHeader: func(buf *[]byte, item *LogItem) {
+					if item.Time != nil {
+						*buf = appendAsctime(*buf, *item.Time)
+						return
+					}
 					now := time.Now()
-					year, mon, day := now.Date()
-					hour, min, sec := now.Clock()
-					nsec := now.Nanosecond()
-					*buf = appendInt(*buf, year, 4)
-					*buf = append(*buf, []byte("-")...)
-					*buf = appendInt(*buf, int(mon), 2)
-					*buf = append(*buf, []byte("-")...)
-					*buf = appendInt(*buf, day, 2)
-					*buf = append(*buf, []byte(" ")...)
-					*buf = appendInt(*buf, hour, 2)
-					*buf = append(*buf, []byte(":")...)
-					*buf = appendInt(*buf, min, 2)
-					*buf = append(*buf, []byte(":")...)
-					*buf = appendInt(*buf, sec, 2)
-					*buf = append(*buf, []byte(".")...)
-					*buf = appendInt(*buf, nsec/1000000, 3)
+					ttl := atomic.LoadInt64(&l.asctimeTTL)
+					if ttl <= 0 {
+						*buf = appendAsctime(*buf, now)
+						return
+					}
+					c, _ := l.asctimeCache.Load().(*cachedAsctime)
+					if c == nil || now.UnixNano() >= c.expiry {
+						c = &cachedAsctime{header: appendAsctime(nil, now), expiry: now.UnixNano() + ttl}
+						l.asctimeCache.Store(c)
+					}
+					*buf = append(*buf, c.header...)
 				},
 			})
 		case "filename":
-			sessions = append(sessions, headerSession{
-				name:   name,
-				isCopy: false,
-				genHeader: func(buf *[]byte, item *logItem) {
-					if len(item.filename) == 0 {
+			sessions = append(sessions, HeaderSession{
+				Name:   name,
+				IsCopy: false,
+				GenHeader: func(buf *[]byte, item *LogItem) {
+					if len(item.Filename) == 0 {
 						initCaller(item)
 					}
-					*buf = append(*buf, item.filename...)
+					*buf = append(*buf, item.Filename...)
 				},
 			})
 		case "function":
-			sessions = append(sessions, headerSession{
-				name:   name,
-				isCopy: false,
-				genHeader: func(buf *[]byte, item *logItem) {
-					if len(item.function) == 0 {
+			sessions = append(sessions, HeaderSession{
+				Name:   name,
+				IsCopy: false,
+				GenHeader: func(buf *[]byte, item *LogItem) {
+					if len(item.Function) == 0 {
 						initCaller(item)
 					}
-					*buf = append(*buf, item.function...)
+					*buf = append(*buf, item.Function...)
 				},
 			})
 		case "lineno":
-			sessions = append(sessions, headerSession{
-				name:   name,
-				isCopy: false,
-				genHeader: func(buf *[]byte, item *logItem) {
-					if item.line < 0 {
+			sessions = append(sessions, HeaderSession{
+				Name:   name,
+				IsCopy: false,
+				GenHeader: func(buf *[]byte, item *LogItem) {
+					if len(item.Filename) == 0 {
 						initCaller(item)
 					}
-					*buf = append(*buf, strconv.Itoa(item.line)...)
+					*buf = append(*buf, strconv.Itoa(item.Line)...)
 				},
 			})
 		case "levelno":
-			sessions = append(sessions, headerSession{
-				name:   name,
-				isCopy: false,
-				genHeader: func(buf *[]byte, item *logItem) {
-					*buf = append(*buf, levels[item.level]...)
+			sessions = append(sessions, HeaderSession{
+				Name:   name,
+				IsCopy: false,
+				GenHeader: func(buf *[]byte, item *LogItem) {
+					*buf = append(*buf, levels[levelIndex(item.Level)]...)
 				},
 			})
 		default:
@@ -413,70 +1049,779 @@ func (l *Logger) setHeaderFormat(fmtStr string) error {
 	}
 
 	if beg < len(fmtStr) {
-		sessions = append(sessions, headerSession{
-			isCopy:  true,
-			strCopy: fmtStr[beg:],
+		sessions = append(sessions, HeaderSession{
+			IsCopy:  true,
+			StrCopy: fmtStr[beg:],
 		})
 	}
-	l.headerSessions = sessions
+	l.headerSessions.Store(sessions)
+	l.format = fmtStr
 	return nil
 }
 
 func (l *Logger) write(msg []byte, level LogLevel) {
+	l.writeItem(&outItem{msg: msg, level: level})
+}
+
+// Write lines contiguously. For async logger lines are joined as one message.
+func (l *Logger) writeLines(lines [][]byte, level LogLevel) {
+	l.asyncMu.RLock()
+	defer l.asyncMu.RUnlock()
+	if len(lines) == 0 {
+		return
+	}
 	if l.async {
-		l.chOut <- &outItem{msg: msg, level: level}
+		var buf []byte
+		for _, line := range lines {
+			buf = append(buf, line...)
+		}
+		l.enqueue(&outItem{msg: buf, level: level})
 	} else {
 		l.mu.Lock()
 		defer l.mu.Unlock()
-		for _, w := range l.outs {
-			w.writer.Write(msg, level)
+		for _, line := range lines {
+			for _, w := range l.outs {
+				w.writer.Write(line, level)
+			}
+			if w := l.levelOuts[levelIndex(level)]; w != nil {
+				w.Write(line, level)
+			}
 		}
 	}
 }
 
-func (l *Logger) output(level LogLevel, calldepth int, s string) {
-	var buf []byte
-
-	item := logItem{
-		level:     level,
-		calldepth: calldepth,
-	}
-	for _, s := range l.headerSessions {
-		if s.isCopy {
-			buf = append(buf, s.strCopy...)
-		} else {
-			s.genHeader(&buf, &item)
-		}
+func (l *Logger) enqueue(item *outItem) {
+	if atomic.LoadInt32(&l.closed) != 0 {
+		return
 	}
-
-	buf = append(buf, s...)
-	if len(s) == 0 || s[len(s)-1] != '\n' {
-		buf = append(buf, '\n')
+	if atomic.LoadInt32(&l.queueAge) != 0 {
+		item.queued = time.Now().UnixNano()
 	}
-	l.write(buf, level)
+	l.chOut <- item
 }
 
-func (l *Logger) Output(level LogLevel, calldepth int, a ...interface{}) {
-	if level >= l.level {
-		l.output(level, calldepth+1, fmt.Sprint(a...))
+func (l *Logger) writeItem(item *outItem) {
+	l.asyncMu.RLock()
+	defer l.asyncMu.RUnlock()
+	if l.async {
+		l.enqueue(item)
+	} else {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, w := range l.outs {
+			writeOut(w.writer, item)
+		}
+		l.writeLevelOut(item)
 	}
 }
 
-func (l *Logger) Outputf(level LogLevel, calldepth int, format string, a ...interface{}) {
-	if level >= l.level {
-		l.output(level, calldepth+1, fmt.Sprintf(format, a...))
+func (l *Logger) writeLevelOut(item *outItem) {
+	if item.group != nil {
+		for _, sub := range item.group {
+			l.writeLevelOut(sub)
+		}
+	} else if w := l.levelOuts[levelIndex(item.level)]; w != nil && item.msg != nil {
+		writeOut(w, item)
 	}
 }
 
-func (l *Logger) Logf(level LogLevel, format string, a ...interface{}) {
-	l.Outputf(level, NormalDepth+1, format, a...)
+func (l *Logger) getHeaderSessions() []HeaderSession {
+	sessions, _ := l.headerSessions.Load().([]HeaderSession)
+	return sessions
 }
 
-func (l *Logger) Debugf(format string, a ...interface{}) {
-	l.Outputf(LevelDebug, NormalDepth+1, format, a...)
+// Replace the header sessions generated from format string of NewLogger(), for custom header segments.
+// A session with IsCopy is output as StrCopy, otherwise GenHeader is called to append to the header.
+func (l *Logger) SetHeaderSessions(sessions []HeaderSession) {
+	l.mu.Lock()
+	l.headerSessions.Store(sessions)
+	l.format = ""
+	l.mu.Unlock()
+	l.callOutputs(func(w IOutput) {
+		if h, ok := w.(headerSessionSetter); ok {
+			h.setHeaderSessions(sessions)
+		}
+	})
 }
 
-func (l *Logger) Infof(format string, a ...interface{}) {
+// item.Calldepth is counted from appendHeader
+func (l *Logger) appendHeader(buf []byte, item *LogItem) []byte {
+	for _, s := range l.getHeaderSessions() {
+		if s.IsCopy {
+			buf = append(buf, s.StrCopy...)
+		} else {
+			s.GenHeader(&buf, item)
+		}
+	}
+	return buf
+}
+
+func (l *Logger) output(level LogLevel, calldepth int, s string) {
+	l.outputAt(nil, level, calldepth+1, s)
+}
+
+// Output the log with time of when if not nil
+func (l *Logger) outputAt(when *time.Time, level LogLevel, calldepth int, s string) {
+	item := LogItem{
+		Level:     level,
+		Calldepth: calldepth + 1,
+		Time:      when,
+	}
+	if l.maxMessageSize > 0 && len(s) > l.maxMessageSize {
+		s = truncateMessage(s, l.maxMessageSize)
+	}
+	buf := l.appendHeader(nil, &item)
+
+	for i := l.scopeSpaces(); i > 0; i-- {
+		buf = append(buf, ' ')
+	}
+	if l.prefix != "" {
+		buf = append(buf, l.prefix...)
+		buf = append(buf, ' ')
+	}
+	buf = append(buf, s...)
+	if len(s) == 0 || s[len(s)-1] != '\n' {
+		buf = append(buf, '\n')
+	}
+	l.write(buf, level)
+
+	if sinks := l.getSinks(); len(sinks) > 0 {
+		l.sendSinks(sinks, when, level, calldepth-1, s)
+	}
+}
+
+// Limit message of logs to n bytes not including the header, such as huge stack traces of a library.
+// Messages exceed are truncated with suffix "...[truncated N bytes]". n <= 0 to not limit, by default.
+func (l *Logger) SetMaxMessageSize(n int) {
+	//SetMaxMessageSize is not locked, same as SetLevel
+	l.maxMessageSize = n
+}
+
+// Cut s at n bytes, or the beginning of the utf-8 character at n
+func truncateMessage(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "...[truncated " + strconv.Itoa(len(s)-n) + " bytes]"
+}
+
+// Return the message with header like a log of LevelInfo, but not write it.
+func (l *Logger) Sprint(a ...interface{}) string {
+	item := LogItem{
+		Level:     LevelInfo,
+		Calldepth: NormalDepth + 1,
+	}
+	buf := l.appendHeader(nil, &item)
+	return string(append(buf, fmt.Sprint(a...)...))
+}
+
+// Return the formatted message with header like a log of LevelInfo, but not write it.
+func (l *Logger) Sprintf(format string, a ...interface{}) string {
+	item := LogItem{
+		Level:     LevelInfo,
+		Calldepth: NormalDepth + 1,
+	}
+	buf := l.appendHeader(nil, &item)
+	return string(append(buf, fmt.Sprintf(format, a...)...))
+}
+
+// Return the header generated for a log of level, without message and '\n', such as for testing header formats.
+// Set calldepth to NormalDepth for the caller of FormatHeader().
+func (l *Logger) FormatHeader(level LogLevel, calldepth int) string {
+	item := LogItem{
+		Level:     level,
+		Calldepth: calldepth + 1,
+	}
+	return string(l.appendHeader(nil, &item))
+}
+
+// Output a banner line like "======== name ========" of LevelInfo as a milestone of sequential logs.
+// ConsoleWriter renders banners with a distinct color.
+func (l *Logger) Checkpoint(name string) {
+	l.checkpoint(NormalDepth+1, name)
+}
+
+func (l *Logger) checkpoint(calldepth int, name string) {
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	item := LogItem{
+		Level:     LevelInfo,
+		Calldepth: calldepth + 1,
+	}
+	buf := l.appendHeader(nil, &item)
+	buf = append(buf, "======== "...)
+	buf = append(buf, name...)
+	buf = append(buf, " ========\n"...)
+	l.writeItem(&outItem{msg: buf, level: LevelInfo, banner: true})
+}
+
+// Return a child logger writes prefix before messages, such as the name of a library. For json logs the prefix
+// is added with key "_prefix". Prefixes of wrapped loggers stack, such as "[db][pool] message".
+// The child logger shares outputs and header format with l, and level of l still takes effect.
+func (l *Logger) Wrap(prefix string) *Logger {
+	return &Logger{
+		loggerCore: l.loggerCore,
+		parent:     l,
+		level:      LevelTrace,
+		prefix:     l.prefix + prefix,
+		scopeDepth: l.scopeDepth,
+	}
+}
+
+// Same as Wrap(fmt.Sprintf(format, args...)), such as logger.WrapF("[conn %d]", id).
+// The prefix is formatted once on called.
+func (l *Logger) WrapF(format string, args ...interface{}) *Logger {
+	return l.Wrap(fmt.Sprintf(format, args...))
+}
+
+// Return a child logger filters logs below level in addition to the level of l, such as to quiet a library
+// logger in tests. The level of l is still checked on each log, so changes of it take effect.
+func (l *Logger) LevelFilter(level LogLevel) *Logger {
+	return &Logger{
+		loggerCore: l.loggerCore,
+		parent:     l,
+		level:      level,
+		prefix:     l.prefix,
+		scopeDepth: l.scopeDepth,
+	}
+}
+
+// Same as LevelFilter(level), logs below level are dropped by the level check before formatting.
+// Methods of a Logger can not be replaced, so the call of filtered methods still happens.
+func (l *Logger) DropBelow(level LogLevel) *Logger {
+	return l.LevelFilter(level)
+}
+
+// Returned by Logger.If(), all methods of Logger are available, and logs are disabled if the condition is false.
+type ConditionalLogger struct {
+	*Logger
+}
+
+// Returns a ConditionalLogger logs through l only if condition is true, such as logger.If(verbose).Debugf("item=%v", item).
+// Messages are not formatted if condition is false, but arguments are still evaluated by the caller.
+func (l *Logger) If(condition bool) *ConditionalLogger {
+	if condition {
+		return &ConditionalLogger{Logger: l}
+	}
+	return &ConditionalLogger{Logger: l.LevelFilter(LevelEmergency + 1)}
+}
+
+// Output lines contiguously, not interleaved with logs of other goroutines, such as a stack trace.
+// Each line is output with the header.
+func (l *Logger) MultiLine(level LogLevel, lines []string) {
+	if !l.enabled(level) {
+		return
+	}
+	l.multiLine(level, NormalDepth+1, lines)
+}
+
+// Output items as an aligned table contiguously like MultiLine(), such as rows of a query result.
+// Columns are the keys of all items sorted, and the first line is the column names.
+func (l *Logger) OutputTable(level LogLevel, items []Json) {
+	if !l.enabled(level) || len(items) == 0 {
+		return
+	}
+	widths := map[string]int{}
+	for _, item := range items {
+		for k, v := range item {
+			if n := utf8.RuneCountInString(fmt.Sprint(v)); n > widths[k] {
+				widths[k] = n
+			}
+			if n := utf8.RuneCountInString(k); n > widths[k] {
+				widths[k] = n
+			}
+		}
+	}
+	keys := make([]string, 0, len(widths))
+	for k := range widths {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	row := func(cell func(k string) string) string {
+		var b strings.Builder
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			s := cell(k)
+			b.WriteString(s)
+			b.WriteString(strings.Repeat(" ", widths[k]-utf8.RuneCountInString(s)))
+		}
+		return strings.TrimRight(b.String(), " ")
+	}
+	lines := make([]string, 0, len(items)+1)
+	lines = append(lines, row(func(k string) string { return k }))
+	for _, item := range items {
+		lines = append(lines, row(func(k string) string {
+			if v, ok := item[k]; ok {
+				return fmt.Sprint(v)
+			}
+			return ""
+		}))
+	}
+	l.multiLine(level, NormalDepth+1, lines)
+}
+
+func (l *Logger) multiLine(level LogLevel, calldepth int, lines []string) {
+	item := LogItem{
+		Level:     level,
+		Calldepth: calldepth + 1,
+	}
+	header := l.appendHeader(nil, &item)
+	bufs := make([][]byte, 0, len(lines))
+	for _, line := range lines {
+		buf := make([]byte, 0, len(header)+len(line)+1)
+		buf = append(buf, header...)
+		buf = append(buf, line...)
+		if len(line) == 0 || line[len(line)-1] != '\n' {
+			buf = append(buf, '\n')
+		}
+		bufs = append(bufs, buf)
+	}
+	l.writeLines(bufs, level)
+}
+
+// Output messages of different levels contiguously as an event, such as the summary of Info with detail of Debug.
+// Messages are output from the highest level, and filtered by level respectively.
+func (l *Logger) OutputMultiLevel(calldepth int, messages map[LogLevel]string) {
+	levels := make([]LogLevel, 0, len(messages))
+	for level := range messages {
+		levels = append(levels, level)
+	}
+	group := make([]*outItem, 0, len(messages))
+	for _, level := range sortLevels(levels) {
+		if !l.enabled(level) {
+			continue
+		}
+		item := LogItem{
+			Level:     level,
+			Calldepth: calldepth + 1,
+		}
+		buf := l.appendHeader(nil, &item)
+		s := messages[level]
+		buf = append(buf, s...)
+		if len(s) == 0 || s[len(s)-1] != '\n' {
+			buf = append(buf, '\n')
+		}
+		group = append(group, &outItem{msg: buf, level: level})
+	}
+	l.writeGroup(group)
+}
+
+// Json version of OutputMultiLevel()
+func (l *Logger) OutputMultiLevelJson(calldepth int, items map[LogLevel]Json) {
+	levels := make([]LogLevel, 0, len(items))
+	for level := range items {
+		levels = append(levels, level)
+	}
+	group := make([]*outItem, 0, len(items))
+	for _, level := range sortLevels(levels) {
+		if !l.enabled(level) {
+			continue
+		}
+		buf := l.formatJson(level, calldepth+1, items[level])
+		if buf != nil {
+			group = append(group, &outItem{msg: buf, level: level})
+		}
+	}
+	l.writeGroup(group)
+}
+
+func (l *Logger) writeGroup(group []*outItem) {
+	if len(group) > 0 {
+		l.writeItem(&outItem{group: group, level: group[0].level})
+	}
+}
+
+// Sort levels from the highest
+func sortLevels(levels []LogLevel) []LogLevel {
+	sort.Slice(levels, func(i, j int) bool { return levels[i] > levels[j] })
+	return levels
+}
+
+// Output a progress line like "[label] 42/100 (42%)". ConsoleWriter rewrites the last progress line in place,
+// other outputs write a line for each update. Call ClearProgress() after finished.
+func (l *Logger) OutputProgress(level LogLevel, total, current int, label string) {
+	if !l.enabled(level) {
+		return
+	}
+	item := LogItem{
+		Level:     level,
+		Calldepth: NormalDepth + 1,
+	}
+	percent := 0
+	if total > 0 {
+		percent = current * 100 / total
+	}
+	buf := l.appendHeader(nil, &item)
+	buf = append(buf, fmt.Sprintf("[%s] %d/%d (%d%%)\n", label, current, total, percent)...)
+	l.writeItem(&outItem{msg: buf, level: level, progress: true})
+}
+
+// Output an empty line to end the in place update of progress lines
+func (l *Logger) ClearProgress() {
+	l.write([]byte("\n"), LevelInfo)
+}
+
+// Output data as hex dump like "hexdump -C", one line for cols bytes, cols defaults to 16 if <= 0.
+// All lines share the same header.
+func (l *Logger) PrintHex(level LogLevel, label string, data []byte, cols int) {
+	l.printHex(level, NormalDepth+1, label, data, cols)
+}
+
+func (l *Logger) printHex(level LogLevel, calldepth int, label string, data []byte, cols int) {
+	if !l.enabled(level) {
+		return
+	}
+	if cols <= 0 {
+		cols = 16
+	}
+
+	item := LogItem{
+		Level:     level,
+		Calldepth: calldepth + 1,
+	}
+	header := l.appendHeader(nil, &item)
+
+	buf := append([]byte{}, header...)
+	buf = append(buf, fmt.Sprintf("%s %d bytes\n", label, len(data))...)
+	l.write(buf, level)
+
+	const hexDigits = "0123456789abcdef"
+	for off := 0; off < len(data); off += cols {
+		line := data[off:]
+		if len(line) > cols {
+			line = line[:cols]
+		}
+		buf := append([]byte{}, header...)
+		buf = append(buf, fmt.Sprintf("%08x ", off)...)
+		for i := 0; i < cols; i++ {
+			if i < len(line) {
+				buf = append(buf, ' ', hexDigits[line[i]>>4], hexDigits[line[i]&0x0f])
+			} else {
+				buf = append(buf, "   "...)
+			}
+		}
+		buf = append(buf, "  |"...)
+		for _, c := range line {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			buf = append(buf, c)
+		}
+		buf = append(buf, "|\n"...)
+		l.write(buf, level)
+	}
+}
+
+// Limit Trace logs to source files match patterns, such as "*/pkg/db/*.go" or "pkg/db/...".
+// A pattern ends with "/..." matches all files under the directory, others are matched by filepath.Match()
+// with the full path and the file name. Set nil to output Trace logs from all files, or by the patterns of
+// the parent for child loggers.
+func (l *Logger) SetTracePackages(patterns []string) {
+	//SetTracePackages is not locked, same as SetLevel
+	l.tracePatterns = patterns
+}
+
+// Check file of the caller at calldepth counted from the caller of traceMatch
+func (l *Logger) traceMatch(calldepth int) bool {
+	var patterns []string
+	for p := l; p != nil && patterns == nil; p = p.parent {
+		patterns = p.tracePatterns
+	}
+	if len(patterns) == 0 {
+		return true
+	}
+	_, file, _, ok := runtime.Caller(calldepth)
+	if !ok {
+		return false
+	}
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "/...") {
+			if strings.Contains(file, strings.TrimSuffix(p, "...")) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := filepath.Match(p, file); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, filepath.Base(file)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Logger) Output(level LogLevel, calldepth int, a ...interface{}) {
+	if !l.enabled(level) || level == LevelTrace && !l.traceMatch(calldepth) {
+		return
+	}
+	l.output(level, calldepth+1, fmt.Sprint(a...))
+}
+
+// Output only if level enabled and condition returns true, condition is called after the level checked and the
+// message is formatted after that, such as to log requests with a debug flag only at LevelDebug.
+func (l *Logger) OutputWhen(level LogLevel, calldepth int, condition func() bool, format string, a ...interface{}) {
+	if !l.enabled(level) || level == LevelTrace && !l.traceMatch(calldepth) {
+		return
+	}
+	if condition() {
+		l.output(level, calldepth+1, fmt.Sprintf(format, a...))
+	}
+}
+
+// Output nothing for the first n occurrences of format, and every occurrence after that, such as a warning of
+// connection retry meaningful only after several failures. Occurrences are counted per format.
+func (l *Logger) OutputAfterN(level LogLevel, n int, format string, a ...interface{}) {
+	v, ok := l.afterN.Load(format)
+	if !ok {
+		v, _ = l.afterN.LoadOrStore(format, new(int64))
+	}
+	if atomic.AddInt64(v.(*int64), 1) <= int64(n) {
+		return
+	}
+	if !l.enabled(level) || level == LevelTrace && !l.traceMatch(NormalDepth+1) {
+		return
+	}
+	l.output(level, NormalDepth+1, fmt.Sprintf(format, a...))
+}
+
+// Reset the occurrences of format counted by OutputAfterN()
+func (l *Logger) ResetAfterN(format string) {
+	l.afterN.Delete(format)
+}
+
+// Output with the probability in [0, 1], such as for sampling logs in a hot loop. 1 is the same as Outputf().
+func (l *Logger) OutputSometimes(probability float64, level LogLevel, calldepth int, format string, a ...interface{}) {
+	if !l.enabled(level) || level == LevelTrace && !l.traceMatch(calldepth) {
+		return
+	}
+	if probability >= 1 || rand.Float64() < probability {
+		l.output(level, calldepth+1, fmt.Sprintf(format, a...))
+	}
+}
+
+// Output the result of tmpl executed with data, for complex formats such as tabular reports.
+// On execution error a LevelError log of the error is output instead.
+func (l *Logger) OutputTemplate(level LogLevel, calldepth int, tmpl *template.Template, data interface{}) {
+	if !l.enabled(level) || level == LevelTrace && !l.traceMatch(calldepth) {
+		return
+	}
+	var b strings.Builder
+	err := errors.New("no template")
+	if tmpl != nil {
+		err = tmpl.Execute(&b, data)
+	}
+	if err != nil {
+		if l.enabled(LevelError) {
+			l.output(LevelError, calldepth+1, fmt.Sprintf("[template error: %v]", err))
+		}
+		return
+	}
+	l.output(level, calldepth+1, b.String())
+}
+
+// Output with the template set by SetDefaultTemplate(), see OutputTemplate()
+func (l *Logger) Templatef(level LogLevel, data interface{}) {
+	var tmpl *template.Template
+	for p := l; p != nil && tmpl == nil; p = p.parent {
+		tmpl = p.tmpl
+	}
+	l.OutputTemplate(level, NormalDepth+1, tmpl, data)
+}
+
+// Set the template of Templatef()
+func (l *Logger) SetDefaultTemplate(tmpl *template.Template) {
+	//SetDefaultTemplate is not locked, same as SetLevel
+	l.tmpl = tmpl
+}
+
+// Output with time of when in the header rather than the current time, such as to backfill historical events.
+func (l *Logger) OutputAt(when time.Time, level LogLevel, calldepth int, format string, a ...interface{}) {
+	if !l.enabled(level) || level == LevelTrace && !l.traceMatch(calldepth) {
+		return
+	}
+	l.outputAt(&when, level, calldepth+1, fmt.Sprintf(format, a...))
+}
+
+func (l *Logger) Outputf(level LogLevel, calldepth int, format string, a ...interface{}) {
+	if !l.enabled(level) || level == LevelTrace && !l.traceMatch(calldepth) {
+		return
+	}
+	l.output(level, calldepth+1, fmt.Sprintf(format, a...))
+}
+
+// Output err as LevelError if not nil, and return err unchanged, such as return logger.OutputError(err, NormalDepth)
+func (l *Logger) OutputError(err error, calldepth int) error {
+	if err != nil && l.enabled(LevelError) {
+		l.output(LevelError, calldepth+1, err.Error())
+	}
+	return err
+}
+
+// Output err and the errors unwrapped from it contiguously as LevelError, one line for each, such as
+// "error: <msg>", "  caused by: <msg>", "    caused by: <root cause>".
+func (l *Logger) PrintError(err error) {
+	if err == nil || !l.enabled(LevelError) {
+		return
+	}
+	lines := []string{"error: " + err.Error()}
+	indent := ""
+	for e := errors.Unwrap(err); e != nil; e = errors.Unwrap(e) {
+		indent += "  "
+		lines = append(lines, indent+"caused by: "+e.Error())
+	}
+	l.multiLine(LevelError, NormalDepth+1, lines)
+}
+
+// Output err as a json log of LevelError, with "causes" of the errors unwrapped from it if any.
+func (l *Logger) PrintErrorJson(err error) {
+	if err == nil {
+		return
+	}
+	items := Json{"error": err.Error()}
+	var causes []string
+	for e := errors.Unwrap(err); e != nil; e = errors.Unwrap(e) {
+		causes = append(causes, e.Error())
+	}
+	if causes != nil {
+		items["causes"] = causes
+	}
+	l.OutputJson(LevelError, NormalDepth+1, items)
+}
+
+func (l *Logger) Log(level LogLevel, a ...interface{}) {
+	l.Output(level, NormalDepth+1, a...)
+}
+
+func (l *Logger) Logf(level LogLevel, format string, a ...interface{}) {
+	l.Outputf(level, NormalDepth+1, format, a...)
+}
+
+// fn is called only if level enabled, for messages expensive to build. Error of fn is logged as LevelError.
+func (l *Logger) Logfn(level LogLevel, fn func() (string, error)) {
+	l.logfn(level, NormalDepth+1, fn)
+}
+
+func (l *Logger) logfn(level LogLevel, calldepth int, fn func() (string, error)) {
+	if !l.enabled(level) {
+		return
+	}
+	s, err := fn()
+	l.output(level, calldepth+1, s)
+	if err != nil {
+		l.Outputf(LevelError, calldepth+1, "build log message error [%v]", err)
+	}
+}
+
+// Same as Output() but returns the message without header, which is formatted even if level disabled.
+func (l *Logger) Outputv(level LogLevel, calldepth int, a ...interface{}) string {
+	s := fmt.Sprint(a...)
+	if l.enabled(level) {
+		l.output(level, calldepth+1, s)
+	}
+	return s
+}
+
+// Same as Outputf() but returns the message without header, which is formatted even if level disabled.
+func (l *Logger) Outputfv(level LogLevel, calldepth int, format string, a ...interface{}) string {
+	s := fmt.Sprintf(format, a...)
+	if l.enabled(level) {
+		l.output(level, calldepth+1, s)
+	}
+	return s
+}
+
+// Same as Output() but returns l for call chaining
+func (l *Logger) OutputChain(level LogLevel, calldepth int, a ...interface{}) *Logger {
+	l.Output(level, calldepth+1, a...)
+	return l
+}
+
+func (l *Logger) DebugChain(a ...interface{}) *Logger {
+	return l.OutputChain(LevelDebug, NormalDepth+1, a...)
+}
+
+func (l *Logger) InfoChain(a ...interface{}) *Logger {
+	return l.OutputChain(LevelInfo, NormalDepth+1, a...)
+}
+
+func (l *Logger) WarnChain(a ...interface{}) *Logger {
+	return l.OutputChain(LevelWarn, NormalDepth+1, a...)
+}
+
+func (l *Logger) ErrorChain(a ...interface{}) *Logger {
+	return l.OutputChain(LevelError, NormalDepth+1, a...)
+}
+
+func (l *Logger) CriticalChain(a ...interface{}) *Logger {
+	return l.OutputChain(LevelCritical, NormalDepth+1, a...)
+}
+
+// Returns a function to be deferred, it logs with the arguments returned by fn on called.
+// Level is checked and fn is evaluated when the returned function called.
+func (l *Logger) DeferLog(level LogLevel, format string, fn func() []interface{}) func() {
+	return func() {
+		if l.enabled(level) {
+			l.output(level, NormalDepth+1, fmt.Sprintf(format, fn()...))
+		}
+	}
+}
+
+// Output msg as plain text, or as "msg" of a json log if followed by key-value pairs like log/slog,
+// such as logger.Info("connected", "addr", addr, "cost", cost). Pairs are detected if the first key is a string.
+func (l *Logger) outputKV(level LogLevel, calldepth int, msg string, keysAndValues []interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	if len(keysAndValues) == 0 {
+		l.output(level, calldepth+1, msg)
+		return
+	}
+	if _, ok := keysAndValues[0].(string); !ok {
+		l.output(level, calldepth+1, fmt.Sprint(append([]interface{}{msg}, keysAndValues...)...))
+		return
+	}
+
+	items := Json{"msg": msg}
+	for i := 0; i < len(keysAndValues); i += 2 {
+		var v interface{}
+		if i+1 < len(keysAndValues) {
+			v = keysAndValues[i+1]
+		}
+		items[fmt.Sprint(keysAndValues[i])] = v
+	}
+	l.OutputJson(level, calldepth+1, items)
+}
+
+func (l *Logger) Debug(msg string, keysAndValues ...interface{}) {
+	l.outputKV(LevelDebug, NormalDepth+1, msg, keysAndValues)
+}
+
+func (l *Logger) Info(msg string, keysAndValues ...interface{}) {
+	l.outputKV(LevelInfo, NormalDepth+1, msg, keysAndValues)
+}
+
+func (l *Logger) Warn(msg string, keysAndValues ...interface{}) {
+	l.outputKV(LevelWarn, NormalDepth+1, msg, keysAndValues)
+}
+
+func (l *Logger) Error(msg string, keysAndValues ...interface{}) {
+	l.outputKV(LevelError, NormalDepth+1, msg, keysAndValues)
+}
+
+func (l *Logger) Tracef(format string, a ...interface{}) {
+	l.Outputf(LevelTrace, NormalDepth+1, format, a...)
+}
+
+func (l *Logger) Debugf(format string, a ...interface{}) {
+	l.Outputf(LevelDebug, NormalDepth+1, format, a...)
+}
+
+func (l *Logger) Infof(format string, a ...interface{}) {
 	l.Outputf(LevelInfo, NormalDepth+1, format, a...)
 }
 
@@ -488,41 +1833,266 @@ func (l *Logger) Errorf(format string, a ...interface{}) {
 	l.Outputf(LevelError, NormalDepth+1, format, a...)
 }
 
+// Create an error by fmt.Errorf() supports %w, log it as LevelError and return it.
+// Such as return logger.Errorfn("connect to %s failed: %w", addr, err)
+func (l *Logger) Errorfn(format string, a ...interface{}) error {
+	return l.errorfn(NormalDepth+1, format, a...)
+}
+
+func (l *Logger) errorfn(calldepth int, format string, a ...interface{}) error {
+	err := fmt.Errorf(format, a...)
+	if l.enabled(LevelError) {
+		l.output(LevelError, calldepth+1, err.Error())
+	}
+	return err
+}
+
+// Output a plain text log like Output(), key-value pairs are not detected for Critical and Emergency logs.
+func (l *Logger) Critical(a ...interface{}) {
+	l.Output(LevelCritical, NormalDepth+1, a...)
+	l.doCriticalAction()
+}
+
 func (l *Logger) Criticalf(format string, a ...interface{}) {
 	l.Outputf(LevelCritical, NormalDepth+1, format, a...)
+	l.doCriticalAction()
+}
+
+func (l *Logger) Emergency(a ...interface{}) {
+	l.Output(LevelEmergency, NormalDepth+1, a...)
+	l.doCriticalAction()
+}
+
+func (l *Logger) Emergencyf(format string, a ...interface{}) {
+	l.Outputf(LevelEmergency, NormalDepth+1, format, a...)
+	l.doCriticalAction()
+}
+
+// Set the action taken after Critical and Emergency logs written, such as func() { os.Exit(1) }. No action by default.
+func (l *Logger) SetCriticalAction(fn func()) {
+	l.criticalAction = fn
+}
+
+// The Critical or Emergency log is flushed to outputs before the action, such as os.Exit(1).
+func (l *Logger) doCriticalAction() {
+	l.flush()
+	for p := l; p != nil; p = p.parent {
+		if p.criticalAction != nil {
+			p.criticalAction()
+			return
+		}
+	}
 }
 
+// Output items as a json log. Keys are always sorted like json.Marshal() of maps, including nested maps,
+// so the output is deterministic for comparing in tests.
 func (l *Logger) OutputJson(level LogLevel, calldepth int, items Json) {
-	if level < l.level {
+	if !l.enabled(level) {
+		return
+	}
+	if l.isJsonPretty() {
+		l.writeLines(l.formatPrettyJson(level, calldepth+1, items), level)
+		return
+	}
+	buf := l.formatJson(level, calldepth+1, items)
+	if buf != nil {
+		l.write(buf, level)
+	}
+}
+
+// Output json log of items with "error" of err, and "causes" if err joins multiple errors such as by errors.Join().
+// The fields are omitted if err is nil.
+func (l *Logger) OutputJsonErr(level LogLevel, calldepth int, err error, items Json) {
+	if !l.enabled(level) {
+		return
+	}
+	if items == nil {
+		items = Json{}
+	}
+	if err != nil {
+		items["error"] = err.Error()
+		if u, ok := err.(interface{ Unwrap() []error }); ok {
+			causes := []string{}
+			for _, e := range u.Unwrap() {
+				causes = append(causes, e.Error())
+			}
+			items["causes"] = causes
+		}
+	}
+	l.OutputJson(level, calldepth+1, items)
+}
+
+// Output json log of defaults merged with items, fields of items override defaults with same keys.
+func (l *Logger) OutputJsonWithDefaults(level LogLevel, calldepth int, defaults Json, items Json) {
+	if !l.enabled(level) {
+		return
+	}
+	merged := make(Json, len(defaults)+len(items))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range items {
+		merged[k] = v
+	}
+	buf := l.formatJson(level, calldepth+1, merged)
+	if buf != nil {
+		l.write(buf, level)
+	}
+}
+
+// Set fields added to every json log, such as service name and version. Fields of the log items are not overridden.
+func (l *Logger) SetDefaultFields(defaults Json) {
+	//SetDefaultFields is not locked, same as SetLevel
+	l.defaultFields = defaults
+}
+
+// Output items as json lines contiguously, not interleaved with logs of other goroutines
+func (l *Logger) OutputJsonArray(level LogLevel, calldepth int, items []Json) {
+	if !l.enabled(level) {
 		return
 	}
+	lines := make([][]byte, 0, len(items))
+	for _, v := range items {
+		buf := l.formatJson(level, calldepth+1, v)
+		if buf != nil {
+			lines = append(lines, buf)
+		}
+	}
+	l.writeLines(lines, level)
+}
+
+// Add fields of context, default fields and prefix to items, fields of items are not overridden.
+// Fields of l override fields of its parent with same keys.
+func (l *Logger) addJsonFields(items Json) {
+	l.visitJsonFields(func(k string) bool {
+		_, ok := items[k]
+		return ok
+	}, func(k string, v interface{}) {
+		items[k] = v
+	})
+	if l.prefix != "" {
+		items["_prefix"] = l.prefix
+	}
+}
 
-	var buf []byte
+// Call add for fields of context and default fields in the order of addJsonFields(), keys has returns true are skipped.
+func (l *Logger) visitJsonFields(has func(k string) bool, add func(k string, v interface{})) {
+	for p := l; p != nil; p = p.parent {
+		for k, v := range p.ctxFields {
+			if !has(k) {
+				add(k, v)
+			}
+		}
+	}
+	for p := l; p != nil; p = p.parent {
+		for _, c := range p.getContexts() {
+			if !has(c.key) {
+				add(c.key, c.valueFn())
+			}
+		}
+	}
+	for p := l; p != nil; p = p.parent {
+		for k, v := range p.defaultFields {
+			if !has(k) {
+				add(k, v)
+			}
+		}
+	}
+}
 
-	item := logItem{
-		level:     level,
-		calldepth: calldepth,
+// Output items as indented json for reading, each line is output with the header.
+func (l *Logger) OutputPrettyJson(level LogLevel, calldepth int, items Json) {
+	if !l.enabled(level) {
+		return
 	}
-	for index, s := range l.headerSessions {
-		if s.isCopy {
+	l.writeLines(l.formatPrettyJson(level, calldepth+1, items), level)
+}
+
+// Returns nil if items can not be marshaled
+func (l *Logger) formatPrettyJson(level LogLevel, calldepth int, items Json) [][]byte {
+	l.addJsonFields(items)
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return nil
+	}
+
+	item := LogItem{
+		Level:     level,
+		Calldepth: calldepth + 1,
+	}
+	header := l.appendHeader(nil, &item)
+	var lines [][]byte
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		buf := make([]byte, 0, len(header)+len(line)+1)
+		buf = append(buf, header...)
+		buf = append(buf, line...)
+		lines = append(lines, append(buf, '\n'))
+	}
+	return lines
+}
+
+// Set false to output json logs by OutputJson() as indented like OutputPrettyJson(), true by default.
+func (l *Logger) SetJsonFormat(compact bool) {
+	//SetJsonFormat is not locked, same as SetLevel
+	if compact {
+		l.jsonFormat = -1
+	} else {
+		l.jsonFormat = 1
+	}
+}
+
+func (l *Logger) isJsonPretty() bool {
+	for p := l; p != nil; p = p.parent {
+		if p.jsonFormat != 0 {
+			return p.jsonFormat > 0
+		}
+	}
+	return false
+}
+
+// Output keys first in json logs of OutputJson() in the order, such as "ts", "level" and "msg" for parsers,
+// and the other keys sorted. Nil to sort all keys, by default, or use the order of the parent for child loggers.
+func (l *Logger) SetFieldOrder(keys []string) {
+	//SetFieldOrder is not locked, same as SetLevel
+	l.fieldOrder = keys
+}
+
+func (l *Logger) getFieldOrder() []string {
+	for p := l; p != nil; p = p.parent {
+		if p.fieldOrder != nil {
+			return p.fieldOrder
+		}
+	}
+	return nil
+}
+
+// Returns nil if items can not be marshaled. Header fields are added to items.
+func (l *Logger) formatJson(level LogLevel, calldepth int, items Json) []byte {
+	l.addJsonFields(items)
+
+	item := LogItem{
+		Level:     level,
+		Calldepth: calldepth,
+	}
+	prefix := ""
+	for index, s := range l.getHeaderSessions() {
+		if s.IsCopy {
 			// ATTENTION if first header session is string const, it will be added to header of json string
 			if index == 0 {
-				buf = append(buf, s.strCopy...)
+				prefix = s.StrCopy
 			}
 		} else {
 			var value []byte
-			s.genHeader(&value, &item)
-			items[s.name] = string(value)
+			s.GenHeader(&value, &item)
+			items[s.Name] = string(value)
 		}
 	}
 
-	bufJson, err := json.Marshal(items)
+	buf, err := appendFields([]byte(prefix), orderedFields(items, l.getFieldOrder()))
 	if err != nil {
-		return
+		return nil
 	}
-	buf = append(buf, bufJson...)
-	buf = append(buf, '\n')
-	l.write(buf, level)
+	return buf
 }
 
 func (l *Logger) LogJson(level LogLevel, items Json) {
@@ -542,14 +2112,25 @@ func (l *Logger) ErrorJson(items Json) {
 	l.OutputJson(LevelError, NormalDepth+1, items)
 }
 func (l *Logger) CriticalJson(items Json) {
-	l.OutputJson(LevelDebug, NormalDepth+1, items)
+	l.OutputJson(LevelCritical, NormalDepth+1, items)
+	l.doCriticalAction()
+}
+func (l *Logger) EmergencyJson(items Json) {
+	l.OutputJson(LevelEmergency, NormalDepth+1, items)
+	l.doCriticalAction()
+}
+func (l *Logger) WarnJsonErr(err error, items Json) {
+	l.OutputJsonErr(LevelWarn, NormalDepth+1, err, items)
+}
+func (l *Logger) ErrorJsonErr(err error, items Json) {
+	l.OutputJsonErr(LevelError, NormalDepth+1, err, items)
 }
 
 // ================ the following functions write to the global logger ================
 
 // ConsoleWriter object used by the global logger.
 var GConsoleWriter = NewConsoleWriter(os.Stderr)
-var std, _ = NewLogger(GConsoleWriter, LevelInfo, "%(asctime) [%(levelno)][%(filename):%(function):%(lineno)] ", false)
+var std, _ = NewLogger(GConsoleWriter, LevelInfo, defaultFormat, false)
 
 // You can use this method to modify settings of the global logger on program start.
 // Since no lock callers should ensure no multi-goroutines access.
@@ -561,11 +2142,23 @@ func Init(out IOutput, level LogLevel, fmtStr string, async bool) error {
 	return err
 }
 
+func SetAsync(ctx context.Context, async bool) error { return std.SetAsync(ctx, async) }
+
 func Level() LogLevel         { return std.Level() }
 func SetLevel(level LogLevel) { std.SetLevel(level) }
 
-func AddOutput(w IOutput)    { std.AddOutput(w) }
-func RemoveOutput(w IOutput) { std.RemoveOutput(w) }
+func Enabled(level LogLevel) bool { return std.Enabled(level) }
+
+func SuppressUntil(d time.Duration)                 { std.SuppressUntil(d) }
+func SuppressLevel(level LogLevel, d time.Duration) { std.SuppressLevel(level, d) }
+
+func AddOutput(w IOutput)                      { std.AddOutput(w) }
+func RemoveOutput(w IOutput)                   { std.RemoveOutput(w) }
+func SwapOutput(old IOutput, new IOutput) bool { return std.SwapOutput(old, new) }
+func SetOutput(w IOutput)                      { std.SetOutput(w) }
+func AddOutputFunc(fn func(msg []byte, level LogLevel)) *FuncOutput {
+	return std.AddOutputFunc(fn)
+}
 
 func AddRedirect(file **os.File, level LogLevel, tag string) *Redirector {
 	return std.AddRedirect(file, level, tag)
@@ -577,13 +2170,55 @@ func Outputf(level LogLevel, calldepth int, format string, a ...interface{}) {
 	std.Outputf(level, calldepth+1, format, a...)
 }
 
+func Checkpoint(name string) { std.checkpoint(NormalDepth+1, name) }
+func Rotate() error          { return std.Rotate() }
+func PrintHex(level LogLevel, label string, data []byte, cols int) {
+	std.printHex(level, NormalDepth+1, label, data, cols)
+}
+func Log(level LogLevel, a ...interface{}) { std.Output(level, NormalDepth+1, a...) }
 func Logf(level LogLevel, format string, a ...interface{}) {
 	std.Outputf(level, NormalDepth+1, format, a...)
 }
+func Logfn(level LogLevel, fn func() (string, error)) {
+	std.logfn(level, NormalDepth+1, fn)
+}
+func Debug(msg string, keysAndValues ...interface{}) {
+	std.outputKV(LevelDebug, NormalDepth+1, msg, keysAndValues)
+}
+func Info(msg string, keysAndValues ...interface{}) {
+	std.outputKV(LevelInfo, NormalDepth+1, msg, keysAndValues)
+}
+func Warn(msg string, keysAndValues ...interface{}) {
+	std.outputKV(LevelWarn, NormalDepth+1, msg, keysAndValues)
+}
+func Error(msg string, keysAndValues ...interface{}) {
+	std.outputKV(LevelError, NormalDepth+1, msg, keysAndValues)
+}
+func Tracef(format string, a ...interface{}) { std.Outputf(LevelTrace, NormalDepth+1, format, a...) }
 func Debugf(format string, a ...interface{}) { std.Outputf(LevelDebug, NormalDepth+1, format, a...) }
 func Infof(format string, a ...interface{})  { std.Outputf(LevelInfo, NormalDepth+1, format, a...) }
 func Warnf(format string, a ...interface{})  { std.Outputf(LevelWarn, NormalDepth+1, format, a...) }
 func Errorf(format string, a ...interface{}) { std.Outputf(LevelError, NormalDepth+1, format, a...) }
+func Errorfn(format string, a ...interface{}) error {
+	return std.errorfn(NormalDepth+1, format, a...)
+}
+func LogError(err error) error { return std.OutputError(err, NormalDepth+1) }
+func Critical(a ...interface{}) {
+	std.Output(LevelCritical, NormalDepth+1, a...)
+	std.doCriticalAction()
+}
 func Criticalf(format string, a ...interface{}) {
 	std.Outputf(LevelCritical, NormalDepth+1, format, a...)
+	std.doCriticalAction()
+}
+func Emergency(a ...interface{}) {
+	std.Output(LevelEmergency, NormalDepth+1, a...)
+	std.doCriticalAction()
 }
+func Emergencyf(format string, a ...interface{}) {
+	std.Outputf(LevelEmergency, NormalDepth+1, format, a...)
+	std.doCriticalAction()
+}
+func SetCriticalAction(fn func()) { std.SetCriticalAction(fn) }
+
+func FlushTimeout(d time.Duration) error { return std.FlushTimeout(d) }
@@ -1,9 +1,24 @@
 package golog_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	stdlog "log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"text/template"
+	"time"
 
 	log "github.com/thinkphoebe/golog"
 )
@@ -29,14 +44,20 @@ func TestConsoleWriter(t *testing.T) {
 
 func TestRotateWriter(t *testing.T) {
 	fmtStr := `%(asctime) [%(levelno)][%(filename):%(lineno)] `
-	w := log.NewRotateWriter("Rotate.log", log.RotateBySize)
+	w, err := log.NewRotateWriter("Rotate.log", log.RotateBySize)
+	if err != nil {
+		t.Fatal(err)
+	}
 	w.SetRotateSize(1000)
 	log.Init(w, log.LevelDebug, fmtStr, false)
 }
 
 func TestAddOutput(t *testing.T) {
 	log.Infof("write to stderr")
-	wFile := log.NewRotateWriter("Multi.log", log.RotateByHour)
+	wFile, err := log.NewRotateWriter("Multi.log", log.RotateByHour)
+	if err != nil {
+		t.Fatal(err)
+	}
 	log.AddOutput(wFile)
 	log.Infof("both write to both stderr and log file")
 	log.Infof("remove file outWriter")
@@ -65,11 +86,2025 @@ func TestAddRedirect(t *testing.T) {
 
 func TestNewLogger(t *testing.T) {
 	fmtStr := `%(asctime:ts) [%(levelno)][%(filename):%(function):%(lineno)] `
-	//logger, err := log.NewLogger(log.NewConsoleWriter(os.Stderr), log.LevelDebug, fmtStr)
-	logger, err := log.NewLogger(log.NewRotateWriter("golog.log", log.RotateByHour), log.LevelDebug, fmtStr, false)
+	w, err := log.NewRotateWriter("golog.log", log.RotateByHour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := log.NewLogger(w, log.LevelDebug, fmtStr, false)
 	if err != nil {
 		return
 	}
 	logger.Infof("hello world")
 	logger.InfoJson(log.Json{"a": 1, "b": "abc", "c": 1.26})
 }
+
+func TestSwapOutput(t *testing.T) {
+	var b1, b2 bytes.Buffer
+	w1 := log.NewConsoleWriter(&b1)
+	w1.SetColored(false)
+	w2 := log.NewConsoleWriter(&b2)
+	w2.SetColored(false)
+	logger, _ := log.NewLogger(w1, log.LevelDebug, "", false)
+	logger.Infof("to w1")
+	if !logger.SwapOutput(w1, w2) {
+		t.Fatal("SwapOutput returns false")
+	}
+	if logger.SwapOutput(w1, w2) {
+		t.Fatal("SwapOutput returns true for removed output")
+	}
+	logger.Infof("to w2")
+	if b1.String() != "to w1\n" || b2.String() != "to w2\n" {
+		t.Fatalf("unexpected output [%s] [%s]", b1.String(), b2.String())
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		old1 := log.NewMemoryWriter()
+		old2 := log.NewMemoryWriter()
+		w := log.NewMemoryWriter()
+		logger, _ := log.NewLogger(old1, log.LevelInfo, "", async)
+		logger.AddOutput(old2)
+		if async {
+			// AddOutput() of async logger is applied by the copy goroutine, not ordered with logs
+			time.Sleep(10 * time.Millisecond)
+		}
+		var before, after []string
+		for i := 0; i < 100; i++ {
+			before = append(before, fmt.Sprintf("before %d\n", i))
+			logger.Infof("before %d", i)
+		}
+		logger.SetOutput(w)
+		for i := 0; i < 100; i++ {
+			after = append(after, fmt.Sprintf("after %d\n", i))
+			logger.Infof("after %d", i)
+		}
+		logger.Close()
+		if async {
+			time.Sleep(100 * time.Millisecond)
+		}
+		for name, m := range map[string]*log.MemoryWriter{"old1": old1, "old2": old2, "new": w} {
+			expected := before
+			if m == w {
+				expected = after
+			}
+			var lines []string
+			for _, e := range m.Entries() {
+				lines = append(lines, string(e.Msg))
+			}
+			if strings.Join(lines, "") != strings.Join(expected, "") {
+				t.Fatalf("async %v, unexpected logs of %s %q", async, name, lines)
+			}
+		}
+	}
+}
+
+func TestNewRotateWriterError(t *testing.T) {
+	w, err := log.NewRotateWriter("not-exist-dir/golog.log", log.RotateByDay)
+	if err == nil || w != nil {
+		t.Fatal("NewRotateWriter should fail on not exist directory")
+	}
+	if !errors.Is(err, os.ErrNotExist) || err.Error() != "log file not-exist-dir/golog.log not writable: "+errors.Unwrap(err).Error() {
+		t.Fatalf("unexpected error [%v]", err)
+	}
+}
+
+func TestCaptureStdLog(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "[%(levelno)][%(filename):%(lineno)] ", false)
+	c := logger.CaptureStdLog()
+	stdlog.Printf("from %s", "stdlog")
+	_, _, line, _ := runtime.Caller(0)
+	c.Close()
+	stdlog.SetOutput(io.Discard)
+	stdlog.Printf("after close")
+	stdlog.SetOutput(os.Stderr)
+	if b.String() != "[I][log_test.go:"+strconv.Itoa(line-1)+"] from stdlog\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestConsoleColumnMode(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	w.SetColumnMode(true)
+	w.SetColumnWidths(map[string]int{"levelno": 3, "function": 30})
+	logger, _ := log.NewLogger(w, log.LevelDebug, "[%(levelno)][%(function)] ", false)
+	logger.Infof("aligned")
+	if b.String() != "[I  ][TestConsoleColumnMode         ] aligned\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestConsoleColumnModeShared(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	w.SetColumnMode(true)
+	w.SetColumnWidths(map[string]int{"levelno": 3})
+	first, _ := log.NewLogger(w, log.LevelDebug, "[%(levelno)] ", false)
+	last, _ := log.NewLogger(w, log.LevelDebug, "<%(levelno)> ", false)
+	first.Infof("not aligned")
+	last.Infof("aligned")
+	if b.String() != "[I] not aligned\n<I  > aligned\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+
+	// header sessions are set from the output goroutine of async, concurrently with writes of last
+	async, _ := log.NewLogger(w, log.LevelDebug, "", true)
+	defer async.Close()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			last.Infof("concurrent")
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		async.SetHeaderSessions([]log.HeaderSession{{IsCopy: true, StrCopy: "["}})
+	}
+	wg.Wait()
+}
+
+func TestAddOutputFunc(t *testing.T) {
+	logger, _ := log.NewLogger(log.NewConsoleWriter(io.Discard), log.LevelDebug, "", false)
+	var lines []string
+	f := logger.AddOutputFunc(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	})
+	logger.Warnf("captured")
+	logger.RemoveOutput(f)
+	logger.Warnf("not captured")
+	if len(lines) != 1 || lines[0] != "captured\n" {
+		t.Fatalf("unexpected output %q", lines)
+	}
+}
+
+func TestCriticalAction(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "", true)
+	defer logger.Close()
+	logger.SetCriticalAction(func() {
+		if b.String() != "fatal\n" {
+			t.Fatalf("action called before log written [%s]", b.String())
+		}
+		b.WriteString("action\n")
+	})
+	logger.Criticalf("fatal")
+	if b.String() != "fatal\naction\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestRingBufferOutput(t *testing.T) {
+	r := log.NewRingBufferOutput(3)
+	logger, _ := log.NewLogger(r, log.LevelDebug, "", false)
+	for i := 0; i < 5; i++ {
+		logger.Infof("%d", i)
+	}
+	lines := r.Lines(0)
+	if len(lines) != 3 || string(lines[0]) != "2\n" || string(lines[2]) != "4\n" {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if lines = r.Lines(1); len(lines) != 1 || string(lines[0]) != "4\n" {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestPrintHex(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "[%(lineno)] ", false)
+	logger.PrintHex(log.LevelInfo, "packet", []byte("Hello\x00golog"), 8)
+	_, _, line, _ := runtime.Caller(0)
+	header := "[" + strconv.Itoa(line-1) + "] "
+	expect := header + "packet 11 bytes\n" +
+		header + "00000000  48 65 6c 6c 6f 00 67 6f  |Hello.go|\n" +
+		header + "00000008  6c 6f 67                 |log|\n"
+	if b.String() != expect {
+		t.Fatalf("unexpected output\n%s", b.String())
+	}
+}
+
+func TestRotateByDayAndSize(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "combined.log")
+	w, err := log.NewRotateWriter(file, log.RotateByDayAndSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetRotateSize(10)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "", false)
+	for i := 0; i < 3; i++ {
+		logger.Infof("more than 10 bytes")
+	}
+	files, _ := filepath.Glob(file + "." + time.Now().Format("2006-01-02") + ".*")
+	if len(files) != 2 {
+		t.Fatalf("unexpected rotated files %v", files)
+	}
+}
+
+func TestRotateGrace(t *testing.T) {
+	for _, grace := range []time.Duration{0, 2 * time.Hour} {
+		file := filepath.Join(t.TempDir(), "app.log")
+		os.WriteFile(file, []byte("last run\n"), 0666)
+		last := time.Now().Add(-time.Hour)
+		os.Chtimes(file, last, last)
+		w, err := log.NewRotateWriter(file, log.RotateByHour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetRotateGrace(grace)
+		w.Write([]byte("this run\n"), log.LevelInfo)
+		files, _ := filepath.Glob(file + ".*")
+		if grace == 0 && len(files) != 1 || grace != 0 && len(files) != 0 {
+			t.Fatalf("unexpected rotated files %v on grace %v", files, grace)
+		}
+	}
+}
+
+func TestSprintf(t *testing.T) {
+	logger, _ := log.NewLogger(log.NewConsoleWriter(io.Discard), log.LevelDebug, "[%(function)] ", false)
+	if s := logger.Sprintf("connect to %s failed", "db"); s != "[TestSprintf] connect to db failed" {
+		t.Fatalf("unexpected string [%s]", s)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetBannerBrush("<banner>")
+	logger, _ := log.NewLogger(w, log.LevelDebug, "", false)
+	logger.Checkpoint("stage 1")
+	if b.String() != "<banner>======== stage 1 ========\n\033[0m" {
+		t.Fatalf("unexpected output %q", b.String())
+	}
+	b.Reset()
+	logger.SetLevel(log.LevelWarn)
+	logger.Checkpoint("stage 2")
+	if b.Len() != 0 {
+		t.Fatalf("checkpoint should be filtered on LevelWarn")
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "", false)
+	ctx := log.AttachGoroutine(context.Background(), log.Json{"req": "r1", "user": "u1"})
+	ctx = log.AttachGoroutine(ctx, log.Json{"user": "u2"})
+	child := logger.FromContext(ctx)
+	child.InfoJson(log.Json{"msg": "hello"})
+	child.DebugJson(log.Json{"msg": "filtered by parent level"})
+	if b.String() != `{"msg":"hello","req":"r1","user":"u2"}`+"\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestAddContext(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "", false)
+	n := 0
+	logger.AddContext("n", func() interface{} { n++; return n })
+	logger.AddContext("state", func() interface{} { return "up" })
+	logger.InfoJson(log.Json{"msg": "a"})
+	logger.RemoveContext("state")
+	logger.InfoJson(log.Json{"msg": "b"})
+	if b.String() != `{"msg":"a","n":1,"state":"up"}`+"\n"+`{"msg":"b","n":2}`+"\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestDefaultFields(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "", false)
+	logger.SetDefaultFields(log.Json{"service": "api", "region": "us"})
+	logger.InfoJson(log.Json{"msg": "a", "region": "eu"})
+	logger.OutputJsonWithDefaults(log.LevelInfo, log.NormalDepth, log.Json{"version": "1", "msg": "x"}, log.Json{"msg": "b"})
+	expected := `{"msg":"a","region":"eu","service":"api"}` + "\n" + `{"msg":"b","region":"us","service":"api","version":"1"}` + "\n"
+	if b.String() != expected {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestFormatHeader(t *testing.T) {
+	logger, _ := log.NewLogger(log.NewFuncOutput(func([]byte, log.LogLevel) {}), log.LevelInfo, "[%(levelno)][%(filename)] ", false)
+	if h := logger.FormatHeader(log.LevelWarn, log.NormalDepth); h != "[W][log_test.go] " {
+		t.Fatalf("unexpected header [%s]", h)
+	}
+}
+
+func TestRotateWriterWithSymlink(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.log")
+	link := filepath.Join(dir, "current.log")
+	w, err := log.NewRotateWriterWithSymlink(file, link, log.RotateBySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetRotateSize(5)
+	w.Write([]byte("hello\n"), log.LevelInfo)
+	// rotated before written since the size exceeded
+	w.Write([]byte("after rotate\n"), log.LevelInfo)
+	data, err := os.ReadFile(link)
+	if err != nil || string(data) != "after rotate\n" {
+		t.Fatalf("unexpected link content [%s] [%v]", data, err)
+	}
+}
+
+func TestSetAsync(t *testing.T) {
+	var lines []string
+	f := log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		time.Sleep(time.Millisecond)
+		lines = append(lines, string(msg))
+	})
+	logger, _ := log.NewLogger(f, log.LevelDebug, "", true)
+	for i := 0; i < 10; i++ {
+		logger.Infof("%d", i)
+	}
+	if err := logger.SetAsync(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 10 {
+		t.Fatalf("%d logs lost on switching to sync", 10-len(lines))
+	}
+	logger.Infof("sync")
+	if len(lines) != 11 {
+		t.Fatal("log not written in sync mode")
+	}
+	logger.SetAsync(context.Background(), true)
+	logger.Infof("async again")
+	logger.Close()
+}
+
+func TestSetAsyncConcurrent(t *testing.T) {
+	var written int32
+	logger, _ := log.NewLogger(log.NewFuncOutput(func([]byte, log.LogLevel) {
+		atomic.AddInt32(&written, 1)
+	}), log.LevelDebug, "", true)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				logger.Infof("%d", i)
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := logger.SetAsync(context.Background(), i%2 == 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	logger.SetAsync(context.Background(), false)
+	if n := atomic.LoadInt32(&written); n != 2000 {
+		t.Fatalf("unexpected logs written %d", n)
+	}
+}
+
+func TestNewLoggerFromStruct(t *testing.T) {
+	cfg := log.LoggerConfig{}
+	err := json.Unmarshal([]byte(`{"level": "warn", "output": "`+filepath.Join(t.TempDir(), "cfg.log")+`", "rotate_mode": "day"}`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := log.NewLoggerFromStruct(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger.Level() != log.LevelWarn {
+		t.Fatalf("unexpected level %d", logger.Level())
+	}
+	if _, err := log.NewLoggerFromStruct(log.LoggerConfig{Level: "verbose"}); err == nil {
+		t.Fatal("unknown level should fail")
+	}
+	if _, err := log.NewLoggerFromStruct(log.LoggerConfig{MaxFiles: 3}); err == nil {
+		t.Fatal("max_files should fail")
+	}
+	if _, err := log.NewLoggerFromStruct(log.LoggerConfig{Compress: true}); err == nil {
+		t.Fatal("compress should fail")
+	}
+
+	// the log file opened is closed if the logger not created
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return
+	}
+	cfg = log.LoggerConfig{Output: filepath.Join(t.TempDir(), "bad.log"), Format: "%(unknown) "}
+	if _, err := log.NewLoggerFromStruct(cfg); err == nil {
+		t.Fatal("unknown header keyword should fail")
+	}
+	if after, _ := os.ReadDir("/proc/self/fd"); len(after) != len(fds) {
+		t.Fatalf("log file not closed, %d fds, %d before", len(after), len(fds))
+	}
+}
+
+func TestOutputJsonArray(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "%(function:func) ", false)
+	logger.OutputJsonArray(log.LevelInfo, log.NormalDepth, []log.Json{{"a": 1}, {"b": 2}})
+	logger.InfoJson(log.Json{"c": 3})
+	expect := `{"a":1,"func":"TestOutputJsonArray"}` + "\n" +
+		`{"b":2,"func":"TestOutputJsonArray"}` + "\n" +
+		`{"c":3,"func":"TestOutputJsonArray"}` + "\n"
+	if b.String() != expect {
+		t.Fatalf("unexpected output\n%s", b.String())
+	}
+}
+
+func TestDeferLog(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "[%(function)] ", false)
+	func() {
+		result := "running"
+		defer logger.DeferLog(log.LevelInfo, "result %s", func() []interface{} { return []interface{}{result} })()
+		result = "done"
+	}()
+	if b.String() != "[TestDeferLog.func1] result done\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestSetHeaderSessions(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "", false)
+	logger.SetHeaderSessions([]log.HeaderSession{
+		{IsCopy: true, StrCopy: "["},
+		{Name: "trace", GenHeader: func(buf *[]byte, item *log.LogItem) {
+			*buf = append(*buf, "trace-1"...)
+		}},
+		{IsCopy: true, StrCopy: "] "},
+	})
+	logger.Infof("custom header")
+	logger.InfoJson(log.Json{"msg": "json"})
+	if b.String() != "[trace-1] custom header\n"+`[{"msg":"json","trace":"trace-1"}`+"\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestReadLastN(t *testing.T) {
+	w, err := log.NewRotateWriter(filepath.Join(t.TempDir(), "tail.log"), log.RotateNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err := w.ReadLastN(3)
+	if err != nil || len(lines) != 0 {
+		t.Fatalf("unexpected result of empty file %q [%v]", lines, err)
+	}
+	for i := 0; i < 2000; i++ {
+		w.Write([]byte(strconv.Itoa(i)+"\n"), log.LevelInfo)
+	}
+	w.Write([]byte("incomplete"), log.LevelInfo)
+	lines, err = w.ReadLastN(3)
+	if err != nil || len(lines) != 3 || string(lines[0]) != "1997" || string(lines[2]) != "1999" {
+		t.Fatalf("unexpected result %q [%v]", lines, err)
+	}
+	for _, n := range []int{0, -1} {
+		if lines, err = w.ReadLastN(n); err != nil || len(lines) != 0 {
+			t.Fatalf("unexpected result of n %d %q [%v]", n, lines, err)
+		}
+	}
+}
+
+func TestRotateWriterStat(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "stat.log")
+	w, err := log.NewRotateWriter(file, log.RotateNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("0123456789\n"), log.LevelInfo)
+	// the first open is counted
+	if w.RotationCount() != 1 {
+		t.Fatalf("unexpected rotation count %d", w.RotationCount())
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("abc\n"), log.LevelInfo)
+	if w.TotalWrittenBytes() != 15 || w.RotationCount() != 2 {
+		t.Fatalf("unexpected written bytes %d, rotation count %d", w.TotalWrittenBytes(), w.RotationCount())
+	}
+	expect := log.RotateStat{File: file, TotalWrittenBytes: 15, RotationCount: 2}
+	if stat := w.Stat(); stat != expect {
+		t.Fatalf("unexpected stat %+v", stat)
+	}
+}
+
+func TestSetLevelOutput(t *testing.T) {
+	var all, errs []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		all = append(all, string(msg))
+	}), log.LevelDebug, "", false)
+	f := log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		errs = append(errs, string(msg))
+	})
+	logger.SetLevelOutput(log.LevelError, f)
+	logger.SetLevelOutput(log.LevelCritical, f)
+	logger.Infof("info")
+	logger.Errorf("error")
+	logger.Criticalf("critical")
+	if len(all) != 3 || len(errs) != 2 || errs[0] != "error\n" {
+		t.Fatalf("unexpected output %q %q", all, errs)
+	}
+}
+
+func TestOutputStructured(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "[%(levelno:level)] ", false)
+	logger.OutputStructured(log.LevelWarn, log.NormalDepth, "done",
+		log.IntField("n", 3), log.StringField("s", "a\"<b>\n"), log.BoolField("ok", true),
+		log.Float64Field("f", 1.5), log.ErrorField(errors.New("failed")), log.DurationField("d", 1500*time.Millisecond))
+	expect := `[{"level":"W","msg":"done","n":3,"s":"a\"\u003cb\u003e\n","ok":true,"f":1.5,"error":"failed","d":"1.5s"}` + "\n"
+	if b.String() != expect {
+		t.Fatalf("unexpected output\n%s", b.String())
+	}
+
+	b.Reset()
+	items := log.Json{"z": []int{1, 2}, "a": "x&y", "m": map[string]int{"k": 1}}
+	logger.InfoJson(items)
+	marshaled, _ := json.Marshal(items)
+	if b.String() != "["+string(marshaled)+"\n" {
+		t.Fatalf("unexpected output\n%s", b.String())
+	}
+}
+
+func TestOutputStructuredFields(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "%(levelno:level)", false)
+	logger.SetDefaultFields(log.Json{"app": "demo", "n": 0})
+	child := logger.Wrap("[db]")
+	child.OutputStructured(log.LevelInfo, log.NormalDepth, "done", log.IntField("n", 1))
+	expect := `{"level":"I","msg":"done","n":1,"app":"demo","_prefix":"[db]"}` + "\n"
+	if b.String() != expect {
+		t.Fatalf("unexpected output\n%s", b.String())
+	}
+
+	b.Reset()
+	logger.SetFieldOrder([]string{"_prefix", "msg"})
+	child.OutputStructured(log.LevelInfo, log.NormalDepth, "done", log.IntField("n", 1))
+	if b.String() != `{"_prefix":"[db]","msg":"done","level":"I","n":1,"app":"demo"}`+"\n" {
+		t.Fatalf("unexpected output\n%s", b.String())
+	}
+	b.Reset()
+	child.InfoJson(log.Json{"msg": "done", "n": 1})
+	if b.String() != `{"_prefix":"[db]","msg":"done","app":"demo","level":"I","n":1}`+"\n" {
+		t.Fatalf("unexpected output\n%s", b.String())
+	}
+}
+
+func TestSetTracePackages(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelTrace, "", false)
+	logger.SetTracePackages([]string{"*_test.go"})
+	logger.Tracef("matched")
+	logger.SetTracePackages([]string{"pkg/db/..."})
+	logger.Tracef("not matched")
+	logger.Debugf("debug not filtered")
+	if len(lines) != 2 || lines[0] != "matched\n" {
+		t.Fatalf("unexpected output %q", lines)
+	}
+}
+
+func TestLevelValues(t *testing.T) {
+	var zero log.LogLevel
+	if zero != log.LevelDebug || log.LevelInfo != 1 || log.LevelCritical != 4 || log.LevelTrace >= log.LevelDebug {
+		t.Fatal("values of existing levels changed")
+	}
+	if log.LevelTag(log.LevelTrace) != "T" || log.LevelTag(log.LevelEmergency) != "M" || log.TagLevel("T") != log.LevelTrace {
+		t.Fatal("unexpected level tags")
+	}
+
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelTrace, "[%(levelno)] ", false)
+	logger.SetLevelOutput(log.LevelTrace, log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, "level output "+string(msg))
+	}))
+	logger.Tracef("trace")
+	if len(lines) != 2 || lines[0] != "[T] trace\n" || lines[1] != "level output [T] trace\n" {
+		t.Fatalf("unexpected output %q", lines)
+	}
+
+	async, _ := log.NewLogger(log.NewMemoryWriter(), log.LevelTrace, "", true)
+	mem := log.NewMemoryWriter()
+	async.SetLevelOutput(log.LevelTrace, mem)
+	defer async.Close()
+	// set by copyRoutine of the async logger
+	time.Sleep(10 * time.Millisecond)
+	async.Tracef("trace")
+	async.FlushTimeout(time.Second)
+	if entries := mem.Entries(); len(entries) != 1 || string(entries[0].Msg) != "trace\n" {
+		t.Fatalf("unexpected level output %v", entries)
+	}
+}
+
+func TestWrapWriter(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "", false)
+	var tee bytes.Buffer
+	w := logger.WrapWriter(&tee, log.LevelWarn)
+	fmt.Fprint(w, "line 1\nline")
+	fmt.Fprint(w, " 2\nincomplete")
+	if len(lines) != 2 || lines[1] != "line 2\n" || tee.String() != "line 1\nline 2\nincomplete" {
+		t.Fatalf("unexpected output %q [%s]", lines, tee.String())
+	}
+}
+
+func TestRotateWriterSyncWrite(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	w, err := log.NewRotateWriter(file, log.RotateNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("before\n"), log.LevelInfo)
+	if err := w.SetSyncWrite(true); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("after\n"), log.LevelInfo)
+	data, _ := os.ReadFile(file)
+	if string(data) != "before\nafter\n" {
+		t.Fatalf("unexpected content [%s]", data)
+	}
+}
+
+func TestOutputProgress(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "", false)
+	logger.OutputProgress(log.LevelInfo, 100, 42, "import")
+	logger.OutputProgress(log.LevelInfo, 100, 100, "import")
+	logger.OutputProgress(log.LevelDebug, 100, 100, "filtered")
+	logger.ClearProgress()
+	if b.String() != "[import] 42/100 (42%)\n[import] 100/100 (100%)\n\n" {
+		t.Fatalf("unexpected output %q", b.String())
+	}
+
+	var lines []string
+	logger, _ = log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelInfo, "", false)
+	logger.OutputProgress(log.LevelInfo, 0, 0, "empty")
+	logger.OutputProgress(log.LevelInfo, 10, 5, "half")
+	if len(lines) != 2 || lines[0] != "[empty] 0/0 (0%)\n" || lines[1] != "[half] 5/10 (50%)\n" {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestFileOutput(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(file, []byte("existing\n"), 0666)
+	f, err := log.NewFileOutput(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := log.NewLogger(f, log.LevelInfo, "", false)
+	logger.Infof("appended")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(file)
+	if string(data) != "existing\nappended\n" {
+		t.Fatalf("unexpected content [%s]", data)
+	}
+	if _, err := log.NewFileOutput("not-exist-dir/app.log"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unexpected error [%v]", err)
+	}
+}
+
+func TestMultiLine(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "[%(levelno)] ", false)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			logger.MultiLine(log.LevelInfo, []string{fmt.Sprintf("g%d 1", i), fmt.Sprintf("g%d 2", i), fmt.Sprintf("g%d 3", i)})
+		}(i)
+	}
+	wg.Wait()
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 300 {
+		t.Fatalf("unexpected line count %d", len(lines))
+	}
+	for i := 0; i < len(lines); i += 3 {
+		g := strings.Fields(lines[i])[1]
+		for j := 0; j < 3; j++ {
+			if lines[i+j] != fmt.Sprintf("[I] %s %d", g, j+1) {
+				t.Fatalf("interleaved line [%s] of %s", lines[i+j], g)
+			}
+		}
+	}
+}
+
+func TestConsoleNoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	w := log.NewConsoleWriter(io.Discard)
+	if w.IsColorEnabled() {
+		t.Fatal("colors should be disabled by NO_COLOR")
+	}
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("TERM", "dumb")
+	w = log.NewConsoleWriter(io.Discard)
+	if w.IsColorEnabled() || w.IsColorSupported() {
+		t.Fatal("colors should be disabled by TERM=dumb")
+	}
+	w.SetColored(true)
+	if !w.IsColorEnabled() {
+		t.Fatal("colors should be enabled by SetColored")
+	}
+}
+
+func TestAddOutputFromDSN(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	logger, _ := log.NewLogger(log.NewFuncOutput(func([]byte, log.LogLevel) {}), log.LevelInfo, "", false)
+	if err := logger.AddOutputFromDSN("file://" + file + "?rotate=day"); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.AddOutputFromDSN("null://"); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.AddOutputFromDSN("ftp://example.com"); !errors.Is(err, log.ErrUnknownScheme) {
+		t.Fatalf("unexpected error [%v]", err)
+	}
+	logger.Infof("to file")
+	data, _ := os.ReadFile(file)
+	if string(data) != "to file\n" {
+		t.Fatalf("unexpected content [%s]", data)
+	}
+}
+
+func TestRotateWriterWriteTimeout(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	w, err := log.NewRotateWriter(file, log.RotateNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWriteTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("in time\n"), log.LevelInfo)
+	data, _ := os.ReadFile(file)
+	if string(data) != "in time\n" || w.Stat().DroppedCount != 0 {
+		t.Fatalf("unexpected content [%s], dropped %d", data, w.DroppedCount())
+	}
+}
+
+func TestExportTo(t *testing.T) {
+	var b bytes.Buffer
+	logger, _ := log.NewLogger(log.NewFuncOutput(func([]byte, log.LogLevel) {}), log.LevelInfo, "[%(levelno)][%(filename)] ", false)
+	logger.ExportTo(json.NewEncoder(&b))
+	logger.Infof("text log")
+	logger.InfoJson(log.Json{"msg": "json log"})
+	logger.StopExport()
+	logger.Infof("not exported")
+	expected := `{"filename":"log_test.go","levelno":"I","msg":"text log"}` + "\n" +
+		`{"filename":"log_test.go","levelno":"I","msg":"json log"}` + "\n"
+	if b.String() != expected {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestSetGlobalPanicHandler(t *testing.T) {
+	var msgs []string
+	log.SetGlobalPanicHandler(func(level log.LogLevel, msg string) {
+		msgs = append(msgs, msg)
+	})
+	defer log.SetGlobalPanicHandler(nil)
+	logger, _ := log.NewLogger(log.NewConsoleWriter(io.Discard), log.LevelInfo, "", false)
+	logger.Fatalf("fatal %d", 1)
+	logger.Panic("panic")
+	if len(msgs) != 2 || msgs[0] != "fatal 1" || msgs[1] != "panic" {
+		t.Fatalf("unexpected messages %q", msgs)
+	}
+
+	log.SetGlobalPanicHandler(nil)
+	defer func() {
+		if r := recover(); r != "restored" {
+			t.Fatalf("unexpected recover [%v]", r)
+		}
+	}()
+	logger.Panic("restored")
+}
+
+func TestLoggerRotate(t *testing.T) {
+	dir := t.TempDir()
+	app, _ := log.NewRotateWriter(filepath.Join(dir, "app.log"), log.RotateNone)
+	audit, _ := log.NewRotateWriter(filepath.Join(dir, "audit.log"), log.RotateNone)
+	logger, _ := log.NewLogger(app, log.LevelInfo, "", false)
+	logger.AddOutput(audit)
+	logger.AddOutput(log.NewConsoleWriter(io.Discard))
+	if !log.SupportsRotation(app) || log.SupportsRotation(log.NewConsoleWriter(io.Discard)) {
+		t.Fatal("unexpected SupportsRotation")
+	}
+	logger.Infof("before rotate")
+	if err := logger.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.log.*"))
+	if len(files) != 2 {
+		t.Fatalf("unexpected rotated files %v", files)
+	}
+}
+
+func TestLoggerMarshalJSON(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	w, _ := log.NewRotateWriter(file, log.RotateByDay)
+	logger, _ := log.NewLogger(w, log.LevelWarn, "[%(levelno)] ", false)
+	logger.AddOutput(log.NewConsoleWriter(os.Stdout))
+	data, err := json.Marshal(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"level":"warn","async":false,"format":"[%(levelno)] ","outputs":["RotateWriter:` + file +
+		`","ConsoleWriter:stdout"],"rotate_modes":{"RotateWriter:` + file + `":"day"}}`
+	if string(data) != expected {
+		t.Fatalf("unexpected json %s", data)
+	}
+
+	var restored log.Logger
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if data2, _ := json.Marshal(&restored); string(data2) != expected {
+		t.Fatalf("unexpected restored json %s", data2)
+	}
+	if err := json.Unmarshal(data, logger); err == nil {
+		t.Fatal("unmarshal into a created logger should fail")
+	}
+	var unknown log.Logger
+	if err := json.Unmarshal([]byte(`{"level":"info","outputs":["Unknown"]}`), &unknown); err == nil {
+		t.Fatal("unknown output should fail")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "[%(levelno)] ", false)
+	pool := logger.Wrap("[db]").Wrap("[pool]")
+	pool.Infof("connected")
+	pool.Debugf("filtered by parent level")
+	pool.InfoJson(log.Json{"msg": "json"})
+	expected := "[I] [db][pool] connected\n" + `[{"_prefix":"[db][pool]","levelno":"I","msg":"json"}` + "\n"
+	if b.String() != expected {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestRegisterLogger(t *testing.T) {
+	logger, _ := log.NewLogger(log.NewConsoleWriter(io.Discard), log.LevelInfo, "", false)
+	log.RegisterLogger("db", logger)
+	if l, ok := log.GetLogger("db"); !ok || l != logger || log.MustGetLogger("db") != logger {
+		t.Fatal("registered logger not found")
+	}
+	if _, ok := log.GetLogger("cache"); ok {
+		t.Fatal("unexpected logger found")
+	}
+	found := false
+	log.RangeLoggers(func(name string, l *log.Logger) bool {
+		found = found || name == "db" && l == logger
+		return true
+	})
+	if !found {
+		t.Fatal("registered logger not ranged")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MustGetLogger should panic")
+		}
+	}()
+	log.MustGetLogger("cache")
+}
+
+func TestSetBrush256(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(true)
+	w.SetBrush256(208, log.LevelWarn)
+	w.SetBrushRGB(255, 0, 128, log.LevelError)
+	w.Write([]byte("w\n"), log.LevelWarn)
+	w.Write([]byte("e\n"), log.LevelError)
+	if b.String() != "\033[38;5;208mw\n\033[0m\033[38;2;255;0;128me\n\033[0m" {
+		t.Fatalf("unexpected output %q", b.String())
+	}
+}
+
+func TestEmergency(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelCritical, "[%(levelno)] ", false)
+	actions := 0
+	logger.SetCriticalAction(func() { actions++ })
+	logger.Emergencyf("disk %s", "lost")
+	logger.EmergencyJson(log.Json{"msg": "json"})
+	if b.String() != "[M] disk lost\n"+`[{"levelno":"M","msg":"json"}`+"\n" || actions != 2 {
+		t.Fatalf("unexpected output [%s], actions %d", b.String(), actions)
+	}
+	cfg, err := log.NewLoggerFromStruct(log.LoggerConfig{Level: "emergency", Output: "stdout"})
+	if err != nil || cfg.Level() != log.LevelEmergency {
+		t.Fatalf("unexpected error [%v]", err)
+	}
+}
+
+func TestLevelFilter(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "", false)
+	quiet := logger.LevelFilter(log.LevelWarn)
+	quiet.Infof("filtered by child")
+	quiet.Warnf("warn")
+	logger.SetLevel(log.LevelError)
+	quiet.Warnf("filtered by parent")
+	logger.Infof("filtered by parent")
+	if b.String() != "warn\n" || quiet.Level() != log.LevelError {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestSuppressLevel(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "", false)
+	logger.SuppressLevel(log.LevelWarn, 50*time.Millisecond)
+	logger.Infof("suppressed")
+	logger.Warnf("warn")
+	logger.Errorf("error")
+	if logger.Enabled(log.LevelInfo) || !logger.Enabled(log.LevelWarn) {
+		t.Fatal("unexpected enabled levels in suppression")
+	}
+	time.Sleep(60 * time.Millisecond)
+	logger.Debugf("expired")
+	if b.String() != "warn\nerror\nexpired\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+
+	b.Reset()
+	logger.SuppressUntil(50 * time.Millisecond)
+	logger.Emergencyf("suppressed")
+	child := logger.Wrap("[child]")
+	child.Emergencyf("suppressed by parent")
+	time.Sleep(60 * time.Millisecond)
+	child.Infof("expired")
+	if b.String() != "[child] expired\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestChildSettings(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "", false)
+	actions := 0
+	logger.SetCriticalAction(func() { actions++ })
+	logger.SetDefaultFields(log.Json{"service": "api"})
+	logger.AddContext("state", func() interface{} { return "up" })
+	logger.SetFieldOrder([]string{"msg"})
+	children := []*log.Logger{
+		logger.FromContext(log.AttachGoroutine(context.Background(), log.Json{"req": 1})),
+		logger.Wrap("[db]"),
+		logger.LevelFilter(log.LevelInfo),
+		logger.If(true).Logger,
+	}
+	for _, child := range children {
+		child.Criticalf("critical")
+		child.Close()
+	}
+	if actions != len(children) {
+		t.Fatalf("unexpected critical actions %d", actions)
+	}
+	b.Reset()
+	children[0].InfoJson(log.Json{"msg": "json"})
+	children[0].SetJsonFormat(false)
+	children[0].InfoJson(log.Json{"msg": "pretty"})
+	logger.InfoJson(log.Json{"msg": "compact"})
+	expected := `{"msg":"json","req":1,"service":"api","state":"up"}` + "\n" +
+		"{\n" + `  "msg": "pretty",` + "\n" + `  "req": 1,` + "\n" + `  "service": "api",` + "\n" +
+		`  "state": "up"` + "\n" + "}\n" +
+		`{"msg":"compact","service":"api","state":"up"}` + "\n"
+	if b.String() != expected {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestPreRotateHook(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	w, _ := log.NewRotateWriter(file, log.RotateNone)
+	var hooked []string
+	w.SetPreRotateHook(func(currentFile string) error {
+		hooked = append(hooked, currentFile)
+		return errors.New("cache busy")
+	})
+	if err := w.Rotate(); err == nil || len(hooked) != 1 || hooked[0] != file {
+		t.Fatalf("unexpected error [%v], hooked %v", err, hooked)
+	}
+	files, _ := filepath.Glob(file + ".*")
+	if len(files) != 0 {
+		t.Fatalf("rotation should be aborted, rotated files %v", files)
+	}
+	w.SetPreRotateHook(func(string) error { return nil })
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScope(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "", false)
+	logger.SetScopeIndent(2)
+	s, end := logger.Scope("init")
+	s.Infof("step 1")
+	logger.Infof("not in scope")
+	_, endDB := s.Scope("db")
+	endDB()
+	end()
+	logger.Infof("after")
+	lines := strings.Split(b.String(), "\n")
+	if len(lines) != 8 || lines[0] != ">>> init" || lines[1] != "  step 1" || lines[2] != "not in scope" ||
+		lines[3] != "  >>> db" || !strings.HasPrefix(lines[4], "  <<< db (") || !strings.HasPrefix(lines[5], "<<< init (") ||
+		lines[6] != "after" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestSetMaxMessageSize(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "[%(levelno)] ", false)
+	logger.SetMaxMessageSize(5)
+	logger.Infof("0123456789")
+	logger.Infof("short")
+	logger.Infof("a\u4e2d\u6587")
+	expected := "[I] 01234...[truncated 5 bytes]\n[I] short\n[I] a\u4e2d...[truncated 3 bytes]\n"
+	if b.String() != expected {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestSetTruncateOnOpen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(file, []byte("last run\n"), 0666)
+	w, _ := log.NewRotateWriter(file, log.RotateNone)
+	if err := w.SetTruncateOnOpen(true); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("this run\n"), log.LevelInfo)
+	data, _ := os.ReadFile(file)
+	if string(data) != "this run\n" {
+		t.Fatalf("unexpected content [%s]", data)
+	}
+	if err := w.SetTruncateOnOpen(true); err == nil {
+		t.Fatal("expected error after written")
+	}
+	if data, _ = os.ReadFile(file); string(data) != "this run\n" {
+		t.Fatalf("unexpected content [%s]", data)
+	}
+}
+
+func TestOutputJsonErr(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "", false)
+	logger.ErrorJsonErr(errors.Join(errors.New("a"), errors.New("b")), log.Json{"op": "save"})
+	logger.WarnJsonErr(nil, nil)
+	expected := `{"causes":["a","b"],"error":"a\nb","op":"save"}` + "\n{}\n"
+	if b.String() != expected {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestErrorfn(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "[%(filename)] ", false)
+	err := logger.Errorfn("connect to %s failed: %w", "db", os.ErrDeadlineExceeded)
+	if !errors.Is(err, os.ErrDeadlineExceeded) || b.String() != "[log_test.go] "+err.Error()+"\n" {
+		t.Fatalf("unexpected error [%v], output [%s]", err, b.String())
+	}
+}
+
+func TestLog(t *testing.T) {
+	mem := log.NewMemoryWriter()
+	logger, _ := log.NewLogger(mem, log.LevelInfo, "[%(levelno)][%(filename):%(lineno)] ", false)
+	logger.Log(log.LevelWarn, "level ", "warn")
+	_, _, line, _ := runtime.Caller(0)
+	logger.Log(log.LevelDebug, "filtered")
+	logger.Log(log.LevelError, "level error")
+	entries := mem.Entries()
+	if len(entries) != 2 || entries[0].Level != log.LevelWarn || entries[1].Level != log.LevelError {
+		t.Fatalf("unexpected entries %q", entries)
+	}
+	if string(entries[0].Msg) != "[W][log_test.go:"+strconv.Itoa(line-1)+"] level warn\n" {
+		t.Fatalf("unexpected output [%s]", entries[0].Msg)
+	}
+	if string(entries[1].Msg) != "[E][log_test.go:"+strconv.Itoa(line+2)+"] level error\n" {
+		t.Fatalf("unexpected output [%s]", entries[1].Msg)
+	}
+}
+
+func TestLogfn(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "[%(levelno)][%(filename)] ", false)
+	logger.Logfn(log.LevelInfo, func() (string, error) { return "built", nil })
+	if b.String() != "[I][log_test.go] built\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+
+	b.Reset()
+	logger.Logfn(log.LevelWarn, func() (string, error) { return "partial", errors.New("failed") })
+	if b.String() != "[W][log_test.go] partial\n[E][log_test.go] build log message error [failed]\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+
+	b.Reset()
+	called := false
+	logger.Logfn(log.LevelDebug, func() (string, error) {
+		called = true
+		return "filtered", nil
+	})
+	if called || b.Len() != 0 {
+		t.Fatalf("fn called for filtered level, output [%s]", b.String())
+	}
+}
+
+func TestOutputv(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "[%(levelno)][%(filename)] ", false)
+	if s := logger.Outputv(log.LevelInfo, log.NormalDepth, "count ", 3); s != "count 3" || b.String() != "[I][log_test.go] count 3\n" {
+		t.Fatalf("unexpected result [%s], output [%s]", s, b.String())
+	}
+	b.Reset()
+	if s := logger.Outputfv(log.LevelWarn, log.NormalDepth, "count %d", 4); s != "count 4" || b.String() != "[W][log_test.go] count 4\n" {
+		t.Fatalf("unexpected result [%s], output [%s]", s, b.String())
+	}
+
+	b.Reset()
+	if s := logger.Outputv(log.LevelDebug, log.NormalDepth, "filtered ", 5); s != "filtered 5" {
+		t.Fatalf("unexpected result [%s]", s)
+	}
+	if s := logger.Outputfv(log.LevelDebug, log.NormalDepth, "filtered %d", 6); s != "filtered 6" {
+		t.Fatalf("unexpected result [%s]", s)
+	}
+	if b.Len() != 0 {
+		t.Fatalf("filtered level written [%s]", b.String())
+	}
+}
+
+func TestAsyncOverflowCallback(t *testing.T) {
+	release := make(chan struct{})
+	var written int32
+	logger, _ := log.NewLogger(log.NewFuncOutput(func([]byte, log.LogLevel) {
+		<-release
+		atomic.AddInt32(&written, 1)
+	}), log.LevelDebug, "", true)
+	var mu sync.Mutex
+	calls, dropped := 0, 0
+	logger.SetAsyncOverflowThrottle(50 * time.Millisecond)
+	logger.SetAsyncOverflowCallback(func(level log.LogLevel, n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		dropped += n
+	})
+	for i := 0; i < log.OutputBuffer; i++ {
+		logger.Debugf("log %d", i)
+	}
+	close(release)
+	logger.Close()
+	// drops after the first callback are reported at the end of the throttle
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	// the number of logs dropped depends on timing, all of them are reported
+	if n := int(atomic.LoadInt32(&written)); calls < 1 || dropped == 0 || dropped+n != log.OutputBuffer {
+		t.Fatalf("unexpected calls %d, dropped %d, written %d", calls, dropped, n)
+	}
+}
+
+func TestRotateWriterBufferSize(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	w, _ := log.NewRotateWriter(file, log.RotateNone)
+	w.SetBufferSize(4096)
+	w.Write([]byte("buffered\n"), log.LevelInfo)
+	if data, _ := os.ReadFile(file); len(data) != 0 {
+		t.Fatalf("unexpected content before flush [%s]", data)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "buffered\n" {
+		t.Fatalf("unexpected content [%s]", data)
+	}
+	w.Write([]byte("rotated\n"), log.LevelInfo)
+	w.Rotate()
+	files, _ := filepath.Glob(file + ".*")
+	if data, _ := os.ReadFile(files[0]); len(files) != 1 || string(data) != "buffered\nrotated\n" {
+		t.Fatalf("unexpected rotated files %v, content [%s]", files, data)
+	}
+}
+
+func TestBufferedCriticalFlushed(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		file := filepath.Join(t.TempDir(), "app.log")
+		w, _ := log.NewRotateWriter(file, log.RotateNone)
+		if err := w.SetBufferSize(4096); err != nil {
+			t.Fatal(err)
+		}
+		logger, _ := log.NewLogger(w, log.LevelInfo, "", async)
+		var data []byte
+		logger.SetCriticalAction(func() { data, _ = os.ReadFile(file) })
+		logger.Infof("info")
+		logger.Criticalf("critical")
+		if string(data) != "info\ncritical\n" {
+			t.Fatalf("async %v, unexpected content on critical action [%s]", async, data)
+		}
+		logger.Infof("closed")
+		logger.Close()
+		if async {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if data, _ = os.ReadFile(file); string(data) != "info\ncritical\nclosed\n" {
+			t.Fatalf("async %v, unexpected content after close [%s]", async, data)
+		}
+	}
+}
+
+func TestRotateWriterBufferWithTimeout(t *testing.T) {
+	w, _ := log.NewRotateWriter(filepath.Join(t.TempDir(), "app.log"), log.RotateNone)
+	if err := w.SetBufferSize(4096); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetWriteTimeout(time.Second); err == nil {
+		t.Fatal("expected error setting write timeout with buffer")
+	}
+	w.SetBufferSize(0)
+	if err := w.SetWriteTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetBufferSize(4096); err == nil {
+		t.Fatal("expected error setting buffer with write timeout")
+	}
+}
+
+func TestWaitForSentinel(t *testing.T) {
+	mem := log.NewMemoryWriter()
+	logger, _ := log.NewLogger(mem, log.LevelInfo, "", true)
+	defer logger.Close()
+	logger.Infof("before sentinel")
+	logger.WriteSentinel(log.LevelInfo, "s1")
+	if err := logger.WaitForSentinel("s1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if entries := mem.Entries(); len(entries) != 2 || string(entries[0].Msg) != "before sentinel\n" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	logger.WriteSentinel(log.LevelDebug, "filtered")
+	if err := logger.WaitForSentinel("filtered", 50*time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error [%v]", err)
+	}
+}
+
+func TestOutputPrettyJson(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "[%(levelno)][%(filename):%(lineno)] ", false)
+	logger.OutputPrettyJson(log.LevelInfo, log.NormalDepth, log.Json{"a": 1, "b": "x"})
+	_, _, line, _ := runtime.Caller(0)
+	header := "[I][log_test.go:" + strconv.Itoa(line-1) + "] "
+	expected := header + "{\n" + header + "  \"a\": 1,\n" + header + "  \"b\": \"x\"\n" + header + "}\n"
+	if b.String() != expected {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+	b.Reset()
+	logger.SetJsonFormat(false)
+	logger.InfoJson(log.Json{"a": 1, "b": "x"})
+	_, _, line, _ = runtime.Caller(0)
+	expected = strings.ReplaceAll(expected, header, "[I][log_test.go:"+strconv.Itoa(line-1)+"] ")
+	if b.String() != expected {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestOutputError(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "[%(levelno)][%(filename)] ", false)
+	err := errors.New("failed")
+	if logger.OutputError(err, log.NormalDepth) != err || logger.OutputError(nil, log.NormalDepth) != nil {
+		t.Fatal("error should be returned unchanged")
+	}
+	if b.String() != "[E][log_test.go] failed\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestSetSessionColor(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(true)
+	w.SetBrush("<warn>", log.LevelWarn)
+	w.SetSessionColor("levelno", "<level>")
+	logger, _ := log.NewLogger(w, log.LevelInfo, "[%(levelno)] ", false)
+	logger.Warnf("colored")
+	if b.String() != "<warn>[<level>W\033[0m<warn>] colored\n\033[0m" {
+		t.Fatalf("unexpected output %q", b.String())
+	}
+}
+
+func TestSetLevelColors(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(true)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "", false)
+	logger.AddOutput(log.NewMemoryWriter())
+	if err := logger.SetLevelColors(map[log.LogLevel]string{log.LevelInfo: "\033[34m", log.LevelWarn: "blue"}); err == nil {
+		t.Fatal("invalid brush should fail")
+	}
+	if err := logger.SetLevelColors(map[log.LogLevel]string{log.LevelInfo: "\033[34m"}); err != nil {
+		t.Fatal(err)
+	}
+	logger.Infof("blue")
+	if b.String() != "\033[34mblue\n\033[0m" {
+		t.Fatalf("unexpected output %q", b.String())
+	}
+}
+
+func TestConsolePrefix(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(true)
+	w.SetBrush("\033[31m", log.LevelWarn)
+	w.SetPrefix("[svc] ")
+	w.SetPrefixBrush("\033[36m")
+	logger, _ := log.NewLogger(w, log.LevelInfo, "", false)
+	logger.Warnf("prefixed")
+	if b.String() != "\033[36m[svc] \033[0m\033[31mprefixed\n\033[0m" {
+		t.Fatalf("unexpected output %q", b.String())
+	}
+
+	b.Reset()
+	w.SetColored(false)
+	logger.Warnf("prefixed")
+	if b.String() != "[svc] prefixed\n" {
+		t.Fatalf("unexpected output %q", b.String())
+	}
+}
+
+func TestOutputChain(t *testing.T) {
+	mem := log.NewMemoryWriter()
+	logger, _ := log.NewLogger(mem, log.LevelInfo, "[%(levelno)] ", false)
+	l := logger.OutputChain(log.LevelInfo, log.NormalDepth, "n=", 1).
+		DebugChain("filtered").
+		InfoChain("info ", "x").
+		WarnChain("warn").
+		ErrorChain("error ", 2).
+		CriticalChain("critical")
+	if l != logger {
+		t.Fatal("logger not returned")
+	}
+	expect := []log.MemoryEntry{
+		{Level: log.LevelInfo, Msg: []byte("[I] n=1\n")},
+		{Level: log.LevelInfo, Msg: []byte("[I] info x\n")},
+		{Level: log.LevelWarn, Msg: []byte("[W] warn\n")},
+		{Level: log.LevelError, Msg: []byte("[E] error 2\n")},
+		{Level: log.LevelCritical, Msg: []byte("[C] critical\n")},
+	}
+	entries := mem.Entries()
+	if len(entries) != len(expect) {
+		t.Fatalf("unexpected entries %q", entries)
+	}
+	for i, e := range entries {
+		if e.Level != expect[i].Level || string(e.Msg) != string(expect[i].Msg) {
+			t.Fatalf("unexpected entry %d, level %d [%s]", i, e.Level, e.Msg)
+		}
+	}
+}
+
+func TestOutputMultiLevel(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "[%(levelno)][%(filename)] ", false)
+	errs := log.NewMemoryWriter()
+	logger.SetLevelOutput(log.LevelDebug, errs)
+	logger.OutputMultiLevel(log.NormalDepth, map[log.LogLevel]string{
+		log.LevelDebug: "detail",
+		log.LevelInfo:  "summary",
+		log.LevelTrace: "filtered",
+	})
+	logger.OutputMultiLevelJson(log.NormalDepth, map[log.LogLevel]log.Json{log.LevelWarn: {"msg": "json"}})
+	if len(lines) != 3 || lines[0] != "[I][log_test.go] summary\n" || lines[1] != "[D][log_test.go] detail\n" ||
+		lines[2] != `[{"filename":"log_test.go","levelno":"W","msg":"json"}`+"\n" {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if entries := errs.Entries(); len(entries) != 1 || string(entries[0].Msg) != "[D][log_test.go] detail\n" {
+		t.Fatalf("unexpected level output %v", entries)
+	}
+}
+
+func TestWithCallerInfo(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "[%(levelno)][%(filename):%(lineno)] ", false, log.WithCallerInfo(false))
+	logger.Infof("no caller")
+	if b.String() != "[I][:] no caller\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestFlushTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var written int32
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		<-release
+		atomic.AddInt32(&written, 1)
+	}), log.LevelDebug, "", true)
+	defer logger.Close()
+
+	logger.Infof("blocked")
+	start := time.Now()
+	if err := logger.FlushTimeout(50 * time.Millisecond); err != log.ErrFlushTimeout {
+		t.Fatalf("expect ErrFlushTimeout, got %v", err)
+	}
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Fatalf("FlushTimeout returned after %v", d)
+	}
+
+	logger.Infof("dropped")
+	close(release)
+	if err := logger.FlushTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&written); n != 1 {
+		t.Fatalf("expect 1 log written, got %d", n)
+	}
+}
+
+func TestKeyValues(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "[%(levelno)][%(filename)] ", false)
+	logger.Info("plain")
+	logger.Warn("connected", "addr", "localhost", "retry", 2)
+	logger.Error("code", 404)
+	logger.Critical("state:", "bad")
+	want := []string{
+		"[I][log_test.go] plain\n",
+		`[{"addr":"localhost","filename":"log_test.go","levelno":"W","msg":"connected","retry":2}` + "\n",
+		"[E][log_test.go] code404\n",
+		"[C][log_test.go] state:bad\n",
+	}
+	if strings.Join(lines, "") != strings.Join(want, "") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestRotateWriterMaxDiskUsage(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "usage.log")
+	w, err := log.NewRotateWriter(file, log.RotateBySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetRotateSize(10)
+	w.SetMaxDiskUsage(50)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "", false)
+	for i := 0; i < 10; i++ {
+		logger.Infof("more than 10 bytes")
+		time.Sleep(time.Millisecond)
+	}
+	// limited on rotate, the active file is written after that
+	usage, err := w.DiskUsage()
+	if err != nil || usage > 50+19 {
+		t.Fatalf("unexpected disk usage %d %v", usage, err)
+	}
+	files, _ := filepath.Glob(file + ".*")
+	if len(files) == 0 || len(files) > 2 {
+		t.Fatalf("unexpected rotated files %v", files)
+	}
+}
+
+func TestWrapF(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "", false)
+	id := 7
+	a := logger.WrapF("[conn %d]", id)
+	c := logger.WrapF("[conn %d]", id)
+	id = 8
+	a.Wrap("[tx]").Infof("begin")
+	a.Infof("a")
+	c.Infof("c")
+	if b.String() != "[conn 7][tx] begin\n[conn 7] a\n[conn 7] c\n" {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestNewColorWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewColorWriter(&b, map[log.LogLevel]string{log.LevelWarn: "\033[34;1m"})
+	w.SetColored(true)
+	w.Write([]byte("w\n"), log.LevelWarn)
+	w.Write([]byte("e\n"), log.LevelError)
+	if b.String() != "\033[34;1mw\n\033[0m\033[31;1me\n\033[0m" {
+		t.Fatalf("unexpected output %q", b.String())
+	}
+}
+
+type formatCounter int
+
+func (c *formatCounter) String() string {
+	*c++
+	return "item"
+}
+
+func TestIf(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "", false)
+	var c formatCounter
+	logger.If(false).Debugf("%v", &c)
+	logger.If(false).Info("kv", "item", &c)
+	logger.If(true).Debugf("%v", &c)
+	if len(lines) != 1 || lines[0] != "item\n" || c != 1 {
+		t.Fatalf("unexpected output %q, formatted %d times", lines, c)
+	}
+}
+
+func TestOutputJsonSortedKeys(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "", false)
+	for i := 0; i < 10; i++ {
+		logger.InfoJson(log.Json{"b": 1, "a": 2, "c": map[string]int{"z": 1, "y": 2, "x": 3}})
+	}
+	for _, line := range lines {
+		if line != `{"a":2,"b":1,"c":{"x":3,"y":2,"z":1}}`+"\n" {
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+}
+
+func TestOutputTemplate(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "[%(levelno)][%(filename)] ", false)
+	tmpl := template.Must(template.New("report").Parse("{{range .}}{{.Name}}={{.Count}} {{end}}"))
+	data := []struct {
+		Name  string
+		Count int
+	}{{"a", 1}, {"b", 2}}
+	logger.OutputTemplate(log.LevelInfo, log.NormalDepth, tmpl, data)
+	logger.SetDefaultTemplate(tmpl)
+	logger.Templatef(log.LevelWarn, data)
+	logger.Templatef(log.LevelInfo, 1)
+	if len(lines) != 3 || lines[0] != "[I][log_test.go] a=1 b=2 \n" || lines[1] != "[W][log_test.go] a=1 b=2 \n" ||
+		!strings.HasPrefix(lines[2], "[E][log_test.go] [template error: ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestStopwatch(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "%(filename) ", false)
+	sw := logger.Stopwatch("load")
+	sw.Split("parse")
+	sw.Stop()
+	if len(lines) != 2 {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	var split, stop map[string]interface{}
+	if json.Unmarshal([]byte(lines[0]), &split) != nil || json.Unmarshal([]byte(lines[1]), &stop) != nil {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if split["filename"] != "log_test.go" || split["stopwatch"] != "load" || split["split"] != "parse" ||
+		split["split_ms"] == nil || !strings.HasPrefix(split["msg"].(string), "load/parse: ") {
+		t.Fatalf("unexpected split %v", split)
+	}
+	if stop["elapsed_ms"] == nil || stop["split"] != nil || !strings.HasSuffix(stop["msg"].(string), "ms total") {
+		t.Fatalf("unexpected stop %v", stop)
+	}
+}
+
+func TestOutputTable(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(false)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "[%(filename)] ", false)
+	logger.OutputTable(log.LevelInfo, []log.Json{
+		{"id": 1, "name": "alice"},
+		{"id": 1024, "name": "bob", "note": "new"},
+	})
+	expected := "[log_test.go] id    name   note\n" +
+		"[log_test.go] 1     alice\n" +
+		"[log_test.go] 1024  bob    new\n"
+	if b.String() != expected {
+		t.Fatalf("unexpected output [%s]", b.String())
+	}
+}
+
+func TestRotateWriterAtomicRotate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "atomic.log")
+	w, err := log.NewRotateWriter(file, log.RotateBySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetRotateSize(10)
+	w.SetAtomicRotate(true)
+	w.SetStagingPath(filepath.Join(dir, "staging"))
+	logger, _ := log.NewLogger(w, log.LevelDebug, "", false)
+	for i := 0; i < 3; i++ {
+		logger.Infof("log line %d", i)
+		time.Sleep(time.Millisecond)
+	}
+	files, _ := filepath.Glob(file + ".*")
+	if len(files) != 2 {
+		t.Fatalf("unexpected rotated files %v", files)
+	}
+	data, _ := os.ReadFile(file)
+	rotated, _ := os.ReadFile(files[0])
+	if string(data) != "log line 2\n" || string(rotated) != "log line 0\n" {
+		t.Fatalf("unexpected content [%s] [%s]", data, rotated)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "staging")); !os.IsNotExist(err) {
+		t.Fatalf("staging file not renamed %v", err)
+	}
+}
+
+func TestSinkChannel(t *testing.T) {
+	logger, _ := log.NewLogger(log.NewMemoryWriter(), log.LevelDebug, "", true)
+	defer logger.Close()
+	ch, stop := logger.SinkChannel(2)
+	logger.Infof("first")
+	logger.Warnf("second")
+	logger.Errorf("dropped")
+	e := <-ch
+	if e.Level != log.LevelInfo || e.Message != "first" || filepath.Base(e.File) != "log_test.go" ||
+		!strings.HasSuffix(e.Function, "TestSinkChannel") || e.Time.IsZero() {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if e = <-ch; e.Message != "second" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	stop()
+	stop()
+	logger.Infof("after stop")
+	if _, ok := <-ch; ok {
+		t.Fatal("channel not closed")
+	}
+}
+
+func TestPrintError(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "[%(filename)] ", false)
+	root := errors.New("connection refused")
+	err := fmt.Errorf("load config: %w", fmt.Errorf("dial: %w", root))
+	logger.PrintError(err)
+	logger.PrintErrorJson(err)
+	logger.PrintError(nil)
+	expected := []string{
+		"[log_test.go] error: load config: dial: connection refused\n",
+		"[log_test.go]   caused by: dial: connection refused\n",
+		"[log_test.go]     caused by: connection refused\n",
+		`[{"causes":["dial: connection refused","connection refused"],"error":"load config: dial: connection refused","filename":"log_test.go"}` + "\n",
+	}
+	if strings.Join(lines, "") != strings.Join(expected, "") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestOutputWhen(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelInfo, "[%(filename)] ", false)
+	calls := 0
+	debugFlag := func() bool {
+		calls++
+		return true
+	}
+	logger.OutputWhen(log.LevelDebug, log.NormalDepth, debugFlag, "filtered %d", 1)
+	logger.OutputWhen(log.LevelInfo, log.NormalDepth, func() bool { return false }, "skipped %d", 2)
+	logger.OutputWhen(log.LevelInfo, log.NormalDepth, debugFlag, "output %d", 3)
+	if calls != 1 || len(lines) != 1 || lines[0] != "[log_test.go] output 3\n" {
+		t.Fatalf("unexpected lines %q, condition called %d times", lines, calls)
+	}
+}
+
+func TestMetricsLogs(t *testing.T) {
+	var b bytes.Buffer
+	mem := log.NewMemoryWriter()
+	logger, _ := log.NewLogger(mem, log.LevelInfo, "[%(levelno)] ", false)
+	logger.AddOutput(log.NewMetricsWriter(&b))
+	logger.IncrCounter("requests", 1)
+	logger.SetGauge("connections", 12.5)
+	logger.RecordHistogram("latency_ms", 30)
+	logger.Infof("not a metric")
+	if b.String() != "requests:1|c\nconnections:12.5|g\nlatency_ms:30|h\n" {
+		t.Fatalf("unexpected statsd output %q", b.String())
+	}
+	msg := string(mem.Entries()[0].Msg)
+	if !strings.HasPrefix(msg, `[{"_type":"counter","delta":1,"levelno":"I","name":"requests","ts":`) {
+		t.Fatalf("unexpected log %q", msg)
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestNewLoggerFromReader(t *testing.T) {
+	r := &closeRecorder{Reader: strings.NewReader(`{"level": "error", "output": "stdout"}`)}
+	logger, err := log.NewLoggerFromReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger.Level() != log.LevelError || !r.closed {
+		t.Fatalf("unexpected level %d, closed %v", logger.Level(), r.closed)
+	}
+	if _, err := log.NewLoggerFromReader(strings.NewReader("{")); err == nil {
+		t.Fatal("invalid json should fail")
+	}
+}
+
+func TestConsoleWriterConcurrent(t *testing.T) {
+	var b bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	w.SetColored(true)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				w.Write([]byte("warn\n"), log.LevelWarn)
+			}
+		}()
+	}
+	wg.Wait()
+	if b.String() != strings.Repeat("\033[33;1mwarn\n\033[0m", 800) {
+		t.Fatal("writes interleaved")
+	}
+}
+
+func TestSetHeaderCacheTime(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "%(asctime) ", false)
+	logger.SetHeaderCacheTime(time.Hour)
+	logger.Infof("a")
+	time.Sleep(5 * time.Millisecond)
+	logger.Infof("b")
+	logger.SetHeaderCacheTime(0)
+	time.Sleep(5 * time.Millisecond)
+	logger.Infof("c")
+	if len(lines) != 3 || strings.TrimSuffix(lines[0], "a\n") != strings.TrimSuffix(lines[1], "b\n") ||
+		strings.TrimSuffix(lines[1], "b\n") == strings.TrimSuffix(lines[2], "c\n") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestDropBelow(t *testing.T) {
+	mem := log.NewMemoryWriter()
+	logger, _ := log.NewLogger(mem, log.LevelDebug, "", false)
+	quiet := logger.DropBelow(log.LevelError)
+	quiet.Infof("dropped")
+	quiet.Warnf("dropped")
+	quiet.Errorf("kept")
+	if entries := mem.Entries(); len(entries) != 1 || string(entries[0].Msg) != "kept\n" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+}
+
+func TestOutputAfterN(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "[%(filename)] ", false)
+	for i := 1; i <= 4; i++ {
+		logger.OutputAfterN(log.LevelWarn, 2, "retry %d", i)
+	}
+	logger.ResetAfterN("retry %d")
+	logger.OutputAfterN(log.LevelWarn, 2, "retry %d", 1)
+	if strings.Join(lines, "") != "[log_test.go] retry 3\n[log_test.go] retry 4\n" {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestRotateWriterDirPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	os.Mkdir(dir, 0755)
+	file := filepath.Join(dir, "perm.log")
+	w, err := log.NewRotateWriter(file, log.RotateNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetDirPermissions(0700)
+	os.RemoveAll(dir)
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("unexpected directory %v %v", info, err)
+	}
+}
+
+func TestOutputAt(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "%(asctime) [%(filename)] ", false)
+	ch, stop := logger.SinkChannel(1)
+	defer stop()
+	when := time.Date(2020, 3, 4, 5, 6, 7, 8000000, time.Local)
+	logger.OutputAt(when, log.LevelInfo, log.NormalDepth, "backfill %d", 1)
+	if len(lines) != 1 || lines[0] != "2020-03-04 05:06:07.008 [log_test.go] backfill 1\n" {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if e := <-ch; !e.Time.Equal(when) || filepath.Base(e.File) != "log_test.go" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+}
+
+func TestSetFieldOrder(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "%(levelno:level)", false)
+	logger.SetFieldOrder([]string{"msg", "level", "missing"})
+	logger.InfoJson(log.Json{"b": 1, "msg": "hello", "a": 2})
+	if len(lines) != 1 || lines[0] != `{"msg":"hello","level":"I","a":2,"b":1}`+"\n" {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestRotateWriterArchive(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "archive.log")
+	w, err := log.NewRotateWriter(file, log.RotateBySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetRotateSize(10)
+	logger, _ := log.NewLogger(w, log.LevelDebug, "", false)
+	for i := 0; i < 3; i++ {
+		logger.Infof("log line %d", i)
+		time.Sleep(time.Millisecond)
+	}
+	dest := filepath.Join(dir, "archive")
+	if err := w.Archive(dest); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(file + ".*"); len(files) != 0 {
+		t.Fatalf("rotated files not removed %v", files)
+	}
+	archives, _ := filepath.Glob(filepath.Join(dest, "archive.log.*.gz"))
+	if len(archives) != 2 {
+		t.Fatalf("unexpected archives %v", archives)
+	}
+	f, _ := os.Open(archives[0])
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(zr)
+	if string(data) != "log line 0\n" {
+		t.Fatalf("unexpected archive content [%s]", data)
+	}
+}
+
+func TestOutputSometimes(t *testing.T) {
+	var lines []string
+	logger, _ := log.NewLogger(log.NewFuncOutput(func(msg []byte, level log.LogLevel) {
+		lines = append(lines, string(msg))
+	}), log.LevelDebug, "[%(filename)] ", false)
+	for i := 0; i < 1000; i++ {
+		logger.OutputSometimes(0, log.LevelInfo, log.NormalDepth, "never")
+	}
+	logger.OutputSometimes(1, log.LevelInfo, log.NormalDepth, "always %d", 1)
+	if len(lines) != 1 || lines[0] != "[log_test.go] always 1\n" {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	lines = nil
+	for i := 0; i < 1000; i++ {
+		logger.OutputSometimes(0.5, log.LevelInfo, log.NormalDepth, "sampled")
+	}
+	if len(lines) < 300 || len(lines) > 700 {
+		t.Fatalf("unexpected sampled count %d", len(lines))
+	}
+}
+
+func TestConsoleTheme(t *testing.T) {
+	var b, themed bytes.Buffer
+	w := log.NewConsoleWriter(&b)
+	tw := log.NewConsoleWriterWithTheme(&themed, log.ThemeDefault)
+	w.SetColored(true)
+	tw.SetColored(true)
+	for level := log.LevelTrace; level <= log.LevelEmergency; level++ {
+		w.Write([]byte("msg\n"), level)
+		tw.Write([]byte("msg\n"), level)
+	}
+	if b.String() != themed.String() {
+		t.Fatalf("ThemeDefault differs from default brushes %q", themed.String())
+	}
+
+	themed.Reset()
+	tw.SetTheme(log.ThemeMonochrome)
+	tw.Write([]byte("e\n"), log.LevelError)
+	if themed.String() != "\033[1;4me\n\033[0m" {
+		t.Fatalf("unexpected output %q", themed.String())
+	}
+}
+
+func TestQueueAge(t *testing.T) {
+	logger, _ := log.NewLogger(log.NewMemoryWriter(), log.LevelInfo, "", true)
+	defer logger.Close()
+	release := make(chan struct{})
+	// level outputs of async loggers are written by the copy goroutine, blocking it blocks the queue
+	logger.SetLevelOutput(log.LevelWarn, log.NewFuncOutput(func([]byte, log.LogLevel) { <-release }))
+	time.Sleep(10 * time.Millisecond)
+	logger.EnableQueueAge(true)
+	logger.Warnf("block")
+	logger.Infof("waiting")
+	time.Sleep(50 * time.Millisecond)
+	if m := logger.Metrics(); m.QueueDepth != 1 {
+		t.Fatalf("unexpected queue depth %d", m.QueueDepth)
+	}
+	close(release)
+	if err := logger.FlushTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if m := logger.Metrics(); m.QueueDepth != 0 || m.LastDequeuedAge < 50*time.Millisecond || m.LastDequeuedAge > time.Second {
+		t.Fatalf("unexpected queue depth %d, age %v", m.QueueDepth, m.LastDequeuedAge)
+	}
+	logger.EnableQueueAge(false)
+	if m := logger.Metrics(); m.LastDequeuedAge != 0 {
+		t.Fatalf("unexpected age %v when disabled", m.LastDequeuedAge)
+	}
+}
@@ -0,0 +1,124 @@
+package golog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Serialized form of a Logger, outputs are identified by String() of them
+type loggerJson struct {
+	Level       string            `json:"level"`
+	Async       bool              `json:"async"`
+	Format      string            `json:"format"`
+	Outputs     []string          `json:"outputs"`
+	RotateModes map[string]string `json:"rotate_modes,omitempty"` // output -> mode name of LoggerConfig, if not none
+}
+
+// Serialize level, header format and outputs of l, such as for auditing the configuration.
+// Outputs are identified by String() if implemented, or by type names.
+func (l *Logger) MarshalJSON() ([]byte, error) {
+	l.asyncMu.RLock()
+	async := l.async
+	l.asyncMu.RUnlock()
+	v := loggerJson{
+		Level:   levelNames[levelIndex(l.Level())],
+		Async:   async,
+		Format:  l.format,
+		Outputs: []string{},
+	}
+	l.callOutputs(func(w IOutput) {
+		if r, ok := w.(*RotateWriter); ok && r.rotateMode != RotateNone {
+			if v.RotateModes == nil {
+				v.RotateModes = map[string]string{}
+			}
+			v.RotateModes[r.String()] = rotateModeName(r.rotateMode)
+		}
+		if s, ok := w.(fmt.Stringer); ok {
+			v.Outputs = append(v.Outputs, s.String())
+		} else {
+			v.Outputs = append(v.Outputs, strings.TrimPrefix(fmt.Sprintf("%T", w), "*golog."))
+		}
+	})
+	return json.Marshal(v)
+}
+
+// Reconstruct a Logger serialized by MarshalJSON() into a zero Logger, such as var l golog.Logger, returns error
+// for a Logger already created. New outputs are created by the output strings, ConsoleWriter, RotateWriter,
+// FileOutput, RingBufferOutput and MemoryWriter are supported.
+func (l *Logger) UnmarshalJSON(data []byte) error {
+	if l.loggerCore != nil {
+		return errors.New("unmarshal into a created logger")
+	}
+	var v loggerJson
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	level, err := parseLevel(v.Level)
+	if err != nil {
+		return err
+	}
+	if len(v.Outputs) == 0 {
+		return errors.New("no outputs")
+	}
+
+	outs := make([]IOutput, 0, len(v.Outputs))
+	for _, s := range v.Outputs {
+		mode, ok := rotateModeNames[v.RotateModes[s]]
+		if !ok {
+			return fmt.Errorf("unknown rotate mode [%s]", v.RotateModes[s])
+		}
+		out, err := newOutputFromString(s, mode)
+		if err != nil {
+			return err
+		}
+		outs = append(outs, out)
+	}
+	n, err := NewLogger(outs[0], level, v.Format, v.Async)
+	if err != nil {
+		return err
+	}
+	for _, out := range outs[1:] {
+		n.AddOutput(out)
+	}
+	*l = *n
+	return nil
+}
+
+// Name of mode in rotateModeNames
+func rotateModeName(mode RotateMode) string {
+	for name, m := range rotateModeNames {
+		if m == mode && name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func newOutputFromString(s string, mode RotateMode) (IOutput, error) {
+	typ, param := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		typ, param = s[:i], s[i+1:]
+	}
+	switch typ {
+	case "ConsoleWriter":
+		if param == "stdout" {
+			return NewConsoleWriter(os.Stdout), nil
+		}
+		return NewConsoleWriter(os.Stderr), nil
+	case "RotateWriter":
+		return NewRotateWriter(param, mode)
+	case "FileOutput":
+		return NewFileOutput(param)
+	case "RingBufferOutput":
+		size, _ := strconv.Atoi(param)
+		return NewRingBufferOutput(size), nil
+	case "MemoryWriter":
+		return NewMemoryWriter(), nil
+	}
+	return nil, fmt.Errorf("unknown output [%s]", s)
+}
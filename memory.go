@@ -0,0 +1,46 @@
+package golog
+
+import "sync"
+
+// A log kept by MemoryWriter
+type MemoryEntry struct {
+	Level LogLevel
+	Msg   []byte
+}
+
+// Keep all logs in memory, such as for checking logs in tests
+type MemoryWriter struct {
+	mu      sync.Mutex
+	entries []MemoryEntry
+}
+
+func NewMemoryWriter() *MemoryWriter {
+	return &MemoryWriter{}
+}
+
+func (w *MemoryWriter) String() string {
+	return "MemoryWriter"
+}
+
+func (w *MemoryWriter) Write(msg []byte, level LogLevel) {
+	entry := MemoryEntry{Level: level, Msg: make([]byte, len(msg))}
+	copy(entry.Msg, msg)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.entries = append(w.entries, entry)
+}
+
+// Return logs written, oldest first
+func (w *MemoryWriter) Entries() []MemoryEntry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]MemoryEntry(nil), w.entries...)
+}
+
+// Drop all logs kept
+func (w *MemoryWriter) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.entries = nil
+}
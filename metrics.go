@@ -0,0 +1,125 @@
+package golog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot of operational counters of a Logger
+type LogMetrics struct {
+	QueueDepth         int           // logs in the async queue
+	LastDequeuedAge    time.Duration // time the last log copied from the async queue waited in it, 0 if not enabled
+	TotalWriters       int
+	HeaderFormatTokens int // keywords in header format, such as %(asctime)
+}
+
+// Record enqueue time of logs for LogMetrics.LastDequeuedAge of async logger, it calls time.Now() on every log
+// enqueued and copied from the queue. The age of the head of the queue is not known, it is newer than the last
+// log copied, so the last one copied is measured instead.
+func (l *Logger) EnableQueueAge(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&l.queueAge, v)
+}
+
+func (l *Logger) Metrics() LogMetrics {
+	m := LogMetrics{
+		TotalWriters: int(atomic.LoadInt32(&l.numOuts)),
+	}
+	l.asyncMu.RLock()
+	if l.async {
+		m.QueueDepth = len(l.chOut)
+	}
+	l.asyncMu.RUnlock()
+	if atomic.LoadInt32(&l.queueAge) != 0 {
+		m.LastDequeuedAge = time.Duration(atomic.LoadInt64(&l.queueWait))
+	}
+	for _, s := range l.getHeaderSessions() {
+		if !s.IsCopy {
+			m.HeaderFormatTokens++
+		}
+	}
+	return m
+}
+
+// Output a json log of LevelInfo for a counter increased by delta, such as
+// {"_type": "counter", "name": "requests", "delta": 1, "ts": 1700000000}. See MetricsOutput to export them.
+func (l *Logger) IncrCounter(name string, delta float64) {
+	l.outputMetric(NormalDepth+1, "counter", name, "delta", delta)
+}
+
+// Output a json log of LevelInfo for a gauge set to value, with "_type" of "gauge" and "value"
+func (l *Logger) SetGauge(name string, value float64) {
+	l.outputMetric(NormalDepth+1, "gauge", name, "value", value)
+}
+
+// Output a json log of LevelInfo for a value of a histogram, with "_type" of "histogram" and "value"
+func (l *Logger) RecordHistogram(name string, value float64) {
+	l.outputMetric(NormalDepth+1, "histogram", name, "value", value)
+}
+
+func (l *Logger) outputMetric(calldepth int, typ string, name string, key string, value float64) {
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	l.OutputJson(LevelInfo, calldepth+1, Json{"_type": typ, "name": name, key: value, "ts": time.Now().Unix()})
+}
+
+var statsdTypes = map[string]string{"counter": "c", "gauge": "g", "histogram": "h"}
+
+// Export metrics logs of IncrCounter(), SetGauge() and RecordHistogram() in StatsD format, other logs are ignored.
+type MetricsOutput struct {
+	dst io.Writer
+}
+
+// Create a MetricsOutput sends metrics to a StatsD server by udp, such as "127.0.0.1:8125"
+func NewMetricsOutput(addr string) (*MetricsOutput, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial statsd %s: %w", addr, err)
+	}
+	return &MetricsOutput{dst: conn}, nil
+}
+
+// Create a MetricsOutput writes metrics in StatsD format to dst, one line each
+func NewMetricsWriter(dst io.Writer) *MetricsOutput {
+	return &MetricsOutput{dst: dst}
+}
+
+func (m *MetricsOutput) String() string {
+	return "MetricsOutput"
+}
+
+func (m *MetricsOutput) Write(msg []byte, level LogLevel) {
+	// json logs may begin with the first string const of header
+	i := bytes.IndexByte(msg, '{')
+	if i < 0 || !bytes.Contains(msg, []byte(`"_type"`)) {
+		return
+	}
+	var metric struct {
+		Type  string   `json:"_type"`
+		Name  string   `json:"name"`
+		Delta *float64 `json:"delta"`
+		Value *float64 `json:"value"`
+	}
+	if json.Unmarshal(msg[i:], &metric) != nil || statsdTypes[metric.Type] == "" || metric.Name == "" {
+		return
+	}
+	value := metric.Value
+	if metric.Type == "counter" {
+		value = metric.Delta
+	}
+	if value == nil {
+		return
+	}
+	line := metric.Name + ":" + strconv.FormatFloat(*value, 'f', -1, 64) + "|" + statsdTypes[metric.Type] + "\n"
+	m.dst.Write([]byte(line))
+}
@@ -0,0 +1,36 @@
+package golog
+
+import "sync"
+
+// Loggers registered by name, such as a logger for each component
+var loggers sync.Map
+
+// Register l with name, the logger registered before with the same name is replaced.
+func RegisterLogger(name string, l *Logger) {
+	loggers.Store(name, l)
+}
+
+// Return the logger registered with name
+func GetLogger(name string) (*Logger, bool) {
+	l, ok := loggers.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return l.(*Logger), true
+}
+
+// Return the logger registered with name, panics if not found.
+func MustGetLogger(name string) *Logger {
+	l, ok := GetLogger(name)
+	if !ok {
+		panic("logger not registered: " + name)
+	}
+	return l
+}
+
+// Call fn for each registered logger until fn returns false, such as to change levels of all components.
+func RangeLoggers(fn func(name string, l *Logger) bool) {
+	loggers.Range(func(key, value interface{}) bool {
+		return fn(key.(string), value.(*Logger))
+	})
+}
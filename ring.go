@@ -0,0 +1,59 @@
+package golog
+
+import (
+	"strconv"
+	"sync"
+)
+
+const defaultRingSize = 1000
+
+// Keep the last logs in memory, such as for viewing recent logs by http
+type RingBufferOutput struct {
+	mu    sync.Mutex
+	lines [][]byte
+	next  int
+	full  bool
+}
+
+// Create a new RingBufferOutput keeps the last size logs
+func NewRingBufferOutput(size int) *RingBufferOutput {
+	if size <= 0 {
+		size = defaultRingSize
+	}
+	return &RingBufferOutput{lines: make([][]byte, size)}
+}
+
+// Returns "RingBufferOutput:" with the size
+func (r *RingBufferOutput) String() string {
+	return "RingBufferOutput:" + strconv.Itoa(len(r.lines))
+}
+
+func (r *RingBufferOutput) Write(msg []byte, level LogLevel) {
+	line := make([]byte, len(msg))
+	copy(line, msg)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines[r.next] = line
+	r.next++
+	if r.next == len(r.lines) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Return the last n logs, oldest first. n <= 0 for all logs kept.
+func (r *RingBufferOutput) Lines(n int) [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var lines [][]byte
+	if r.full {
+		lines = append(lines, r.lines[r.next:]...)
+	}
+	lines = append(lines, r.lines[:r.next]...)
+	if n > 0 && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
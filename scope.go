@@ -0,0 +1,46 @@
+package golog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Output ">>> name" and return a child logger for logs in the scope, and a function outputs "<<< name (elapsed)":
+//
+//	s, end := l.Scope("init")
+//	defer end()
+//	s.Infof("step 1")
+//
+// Logs of the child logger are indented if SetScopeIndent() called, scopes are nested by s.Scope().
+func (l *Logger) Scope(name string) (*Logger, func()) {
+	begin := time.Now()
+	if l.enabled(LevelInfo) {
+		l.output(LevelInfo, NormalDepth+1, ">>> "+name)
+	}
+	child := &Logger{
+		loggerCore: l.loggerCore,
+		parent:     l,
+		level:      LevelTrace,
+		prefix:     l.prefix,
+		scopeDepth: l.scopeDepth + 1,
+	}
+	return child, func() {
+		if l.enabled(LevelInfo) {
+			l.output(LevelInfo, NormalDepth+1, "<<< "+name+" ("+time.Since(begin).String()+")")
+		}
+	}
+}
+
+// Indent logs in scopes by spaces for each level of scopes, 0 by default to not indent.
+func (l *Logger) SetScopeIndent(spaces int) {
+	atomic.StoreInt32(&l.scopeIndent, int32(spaces))
+}
+
+// Spaces to indent for scopes of l
+func (l *Logger) scopeSpaces() int {
+	indent := atomic.LoadInt32(&l.scopeIndent)
+	if indent <= 0 {
+		return 0
+	}
+	return l.scopeDepth * int(indent)
+}
@@ -0,0 +1,54 @@
+package golog
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+const sentinelPrefix = "golog sentinel "
+
+// Output a log tagged by id for WaitForSentinel(), such as to wait for the previous logs of an async logger written.
+func (l *Logger) WriteSentinel(level LogLevel, id string) {
+	if l.enabled(level) {
+		l.output(level, NormalDepth+1, sentinelPrefix+id)
+	}
+}
+
+// Wait until the log written by WriteSentinel() with id is seen by a MemoryWriter or RingBufferOutput of l.
+// Returns context.DeadlineExceeded on timeout, such as the sentinel filtered by level.
+func (l *Logger) WaitForSentinel(id string, timeout time.Duration) error {
+	tag := []byte(sentinelPrefix + id)
+	deadline := time.Now().Add(timeout)
+	for {
+		var mu sync.Mutex
+		found := false
+		l.callOutputs(func(w IOutput) {
+			var lines [][]byte
+			switch o := w.(type) {
+			case *MemoryWriter:
+				for _, e := range o.Entries() {
+					lines = append(lines, e.Msg)
+				}
+			case *RingBufferOutput:
+				lines = o.Lines(0)
+			}
+			for _, line := range lines {
+				if bytes.HasSuffix(bytes.TrimRight(line, "\n"), tag) {
+					mu.Lock()
+					found = true
+					mu.Unlock()
+					return
+				}
+			}
+		})
+		if found {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return context.DeadlineExceeded
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
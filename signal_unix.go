@@ -0,0 +1,69 @@
+//go:build unix
+
+package golog
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Loggers rotated on SIGHUP, all share a signal channel
+var sighup struct {
+	mu      sync.Mutex
+	loggers map[*Logger]struct{}
+	ch      chan os.Signal
+}
+
+// Rotate outputs of l by Rotate() on SIGHUP received, such as sent by logrotate.
+// Multiple loggers can be installed, they are all rotated on a signal.
+func (l *Logger) InstallSIGHUPHandler() {
+	sighup.mu.Lock()
+	defer sighup.mu.Unlock()
+	if sighup.loggers == nil {
+		sighup.loggers = map[*Logger]struct{}{}
+	}
+	sighup.loggers[l] = struct{}{}
+	if sighup.ch == nil {
+		sighup.ch = make(chan os.Signal, 1)
+		signal.Notify(sighup.ch, syscall.SIGHUP)
+		go handleSIGHUP(sighup.ch)
+	}
+}
+
+// Stop rotating l on SIGHUP, the signal is not handled after all loggers uninstalled.
+func (l *Logger) UninstallSIGHUPHandler() {
+	sighup.mu.Lock()
+	defer sighup.mu.Unlock()
+	delete(sighup.loggers, l)
+	if len(sighup.loggers) == 0 && sighup.ch != nil {
+		signal.Stop(sighup.ch)
+		close(sighup.ch)
+		sighup.ch = nil
+	}
+}
+
+func handleSIGHUP(ch chan os.Signal) {
+	for range ch {
+		sighup.mu.Lock()
+		loggers := make([]*Logger, 0, len(sighup.loggers))
+		for l := range sighup.loggers {
+			loggers = append(loggers, l)
+		}
+		sighup.mu.Unlock()
+
+		for _, l := range loggers {
+			if err := l.Rotate(); err != nil {
+				fmt.Fprintf(os.Stderr, "golog rotate on SIGHUP error [%v]\n", err)
+			}
+		}
+	}
+}
+
+// Rotate outputs of the global logger on SIGHUP, see Logger.InstallSIGHUPHandler()
+func InstallSIGHUPHandler() { std.InstallSIGHUPHandler() }
+
+// Stop rotating the global logger on SIGHUP
+func UninstallSIGHUPHandler() { std.UninstallSIGHUPHandler() }
@@ -0,0 +1,29 @@
+//go:build unix
+
+package golog_test
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	log "github.com/thinkphoebe/golog"
+)
+
+func TestInstallSIGHUPHandler(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	w, _ := log.NewRotateWriter(file, log.RotateNone)
+	logger, _ := log.NewLogger(w, log.LevelInfo, "", false)
+	logger.InstallSIGHUPHandler()
+	defer logger.UninstallSIGHUPHandler()
+	logger.Infof("before SIGHUP")
+	syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+	for i := 0; i < 100; i++ {
+		if files, _ := filepath.Glob(file + ".*"); len(files) == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("log file not rotated on SIGHUP")
+}
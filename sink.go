@@ -0,0 +1,92 @@
+package golog
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// A log sent by the channel of SinkChannel()
+type LogEntry struct {
+	Level    LogLevel
+	Time     time.Time
+	File     string
+	Line     int
+	Function string
+	Message  string // without header and prefix
+}
+
+// Registered by SinkChannel(), logs are dropped if ch is full
+type channelOutput struct {
+	mu     sync.Mutex
+	ch     chan LogEntry
+	closed bool
+}
+
+func (c *channelOutput) send(e LogEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.ch <- e:
+	default:
+	}
+}
+
+// Returns a channel receives logs as LogEntry, such as for real-time monitoring or checking logs in tests,
+// and a function to stop and close the channel. Logs are sent without blocking the logger, and dropped if
+// bufSize logs not received. Json logs and logs written contiguously such as MultiLine() are not sent.
+func (l *Logger) SinkChannel(bufSize int) (<-chan LogEntry, func()) {
+	c := &channelOutput{ch: make(chan LogEntry, bufSize)}
+	l.mu.Lock()
+	l.sinks.Store(append(l.getSinks(), c))
+	l.mu.Unlock()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			l.mu.Lock()
+			old := l.getSinks()
+			sinks := make([]*channelOutput, 0, len(old))
+			for _, s := range old {
+				if s != c {
+					sinks = append(sinks, s)
+				}
+			}
+			l.sinks.Store(sinks)
+			l.mu.Unlock()
+
+			c.mu.Lock()
+			c.closed = true
+			close(c.ch)
+			c.mu.Unlock()
+		})
+	}
+	return c.ch, stop
+}
+
+func (l *Logger) getSinks() []*channelOutput {
+	sinks, _ := l.sinks.Load().([]*channelOutput)
+	return sinks
+}
+
+// Send the log to channels of SinkChannel(), caller of the log is runtime.Caller(skip)
+func (l *Logger) sendSinks(sinks []*channelOutput, when *time.Time, level LogLevel, skip int, msg string) {
+	e := LogEntry{Level: level, Time: time.Now(), Message: msg}
+	if when != nil {
+		e.Time = *when
+	}
+	pc, file, line, ok := runtime.Caller(skip + 1)
+	if ok {
+		e.File = file
+		e.Line = line
+		if f := runtime.FuncForPC(pc); f != nil {
+			e.Function = f.Name()
+		}
+	}
+	for _, c := range sinks {
+		c.send(e)
+	}
+}
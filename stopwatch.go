@@ -0,0 +1,52 @@
+package golog
+
+import (
+	"fmt"
+	"time"
+)
+
+// Time named splits of a task, created by Logger.Stopwatch(). Not safe for concurrent use.
+type Stopwatch struct {
+	l     *Logger
+	name  string
+	start time.Time
+	last  time.Time
+}
+
+// Start a Stopwatch, splits and the total time are output as json logs of LevelDebug.
+func (l *Logger) Stopwatch(name string) *Stopwatch {
+	now := time.Now()
+	return &Stopwatch{l: l, name: name, start: now, last: now}
+}
+
+// Output time since start and since the last split, such as {"msg": "load/parse: 30ms since start, 12ms since
+// last split", "stopwatch": "load", "split": "parse", "elapsed_ms": 30, "split_ms": 12}.
+func (s *Stopwatch) Split(splitName string) {
+	now := time.Now()
+	elapsed := now.Sub(s.start).Milliseconds()
+	split := now.Sub(s.last).Milliseconds()
+	s.last = now
+	if !s.l.enabled(LevelDebug) {
+		return
+	}
+	s.l.OutputJson(LevelDebug, NormalDepth+1, Json{
+		"msg":        fmt.Sprintf("%s/%s: %dms since start, %dms since last split", s.name, splitName, elapsed, split),
+		"stopwatch":  s.name,
+		"split":      splitName,
+		"elapsed_ms": elapsed,
+		"split_ms":   split,
+	})
+}
+
+// Output the total time since start
+func (s *Stopwatch) Stop() {
+	if !s.l.enabled(LevelDebug) {
+		return
+	}
+	elapsed := time.Since(s.start).Milliseconds()
+	s.l.OutputJson(LevelDebug, NormalDepth+1, Json{
+		"msg":        fmt.Sprintf("%s: %dms total", s.name, elapsed),
+		"stopwatch":  s.name,
+		"elapsed_ms": elapsed,
+	})
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package golog
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// Write logs to syslog with priority mapped from level
+type syslogOutput struct {
+	w *syslog.Writer
+}
+
+func newSyslogOutput(network, addr, tag string) (IOutput, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return nil, fmt.Errorf("connect syslog: %w", err)
+	}
+	return &syslogOutput{w: w}, nil
+}
+
+func (s *syslogOutput) Write(msg []byte, level LogLevel) {
+	m := string(msg)
+	switch level {
+	case LevelTrace, LevelDebug:
+		s.w.Debug(m)
+	case LevelInfo:
+		s.w.Info(m)
+	case LevelWarn:
+		s.w.Warning(m)
+	case LevelError:
+		s.w.Err(m)
+	case LevelCritical:
+		s.w.Crit(m)
+	default:
+		s.w.Emerg(m)
+	}
+}
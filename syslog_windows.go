@@ -0,0 +1,7 @@
+package golog
+
+import "errors"
+
+func newSyslogOutput(network, addr, tag string) (IOutput, error) {
+	return nil, errors.New("syslog is not supported on windows")
+}
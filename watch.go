@@ -0,0 +1,85 @@
+package golog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Interval of checking the file of Watch(), changed by tests
+var watchInterval = time.Second
+
+type watchConfig struct {
+	Level string `json:"level"`
+}
+
+// Set level from a json config file like {"level": "debug"}, and reload it on modified.
+// The file is checked every second in a background goroutine until Unwatch() called.
+func (l *Logger) Watch(file string) error {
+	if l.watchStop != nil {
+		return errors.New("already watching")
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	err = l.loadLevel(file)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	l.watchStop = stop
+	go func() {
+		modTime := info.ModTime()
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(file)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			err = l.loadLevel(file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Logger reload level error [%v]\n", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop watching started by Watch(), the file is not reloaded after returned.
+func (l *Logger) Unwatch() {
+	if l.watchStop != nil {
+		// received by the goroutine before it returns
+		l.watchStop <- struct{}{}
+		close(l.watchStop)
+		l.watchStop = nil
+	}
+}
+
+func (l *Logger) loadLevel(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var cfg watchConfig
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", file, err)
+	}
+	level, err := parseLevel(cfg.Level)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", file, err)
+	}
+	l.SetLevel(level)
+	return nil
+}
@@ -0,0 +1,64 @@
+package golog
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	interval := watchInterval
+	watchInterval = 10 * time.Millisecond
+	defer func() { watchInterval = interval }()
+
+	file := filepath.Join(t.TempDir(), "level.json")
+	os.WriteFile(file, []byte(`{"level": "warn"}`), 0644)
+	l, _ := NewLogger(NewMemoryWriter(), LevelInfo, "", false)
+	goroutines := runtime.NumGoroutine()
+	if err := l.Watch(file); err != nil {
+		t.Fatal(err)
+	}
+	if l.Level() != LevelWarn {
+		t.Fatalf("unexpected level %d on watch", l.Level())
+	}
+	if err := l.Watch(file); err == nil {
+		t.Fatal("expected error on watching twice")
+	}
+
+	// the modify time is set explicitly, writes in a short time may have the same one
+	reload := func(content string, modTime time.Time) {
+		os.WriteFile(file, []byte(content), 0644)
+		os.Chtimes(file, modTime, modTime)
+	}
+	// SetLevel() is not locked, the level is checked after Unwatch() which synchronizes with the goroutine
+	now := time.Now()
+	reload(`{"level": "debug"}`, now.Add(time.Second))
+	time.Sleep(100 * time.Millisecond)
+	l.Unwatch()
+	if l.Level() != LevelDebug {
+		t.Fatalf("level not reloaded, %d", l.Level())
+	}
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Fatalf("watch goroutine not exited, %d goroutines, %d before", n, goroutines)
+	}
+	reload(`{"level": "error"}`, now.Add(2*time.Second))
+	time.Sleep(50 * time.Millisecond)
+	if l.Level() != LevelDebug {
+		t.Fatalf("level reloaded after unwatch, %d", l.Level())
+	}
+
+	if err := l.Watch(file); err != nil || l.Level() != LevelError {
+		t.Fatalf("unexpected watch again error [%v], level %d", err, l.Level())
+	}
+	reload(`{"level": "verbose"}`, now.Add(3*time.Second))
+	time.Sleep(100 * time.Millisecond)
+	l.Unwatch()
+	if l.Level() != LevelError {
+		t.Fatalf("invalid level applied, %d", l.Level())
+	}
+}